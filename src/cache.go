@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"xenapi"
+)
+
+// How long a single event.from call blocks waiting for events
+const eventFromTimeout = 30.0
+
+// How long to wait before following the events again after a failure
+const cacheRetryInterval = 10 * time.Second
+
+// mirroredClass is a class of XAPI objects kept in memory by ObjectCache.
+type mirroredClass interface {
+	resync(session *xenapi.Session, events []xenapi.EventRecord)
+	apply(session *xenapi.Session, event xenapi.EventRecord) error
+}
+
+type mirror[Ref ~string, Record any] struct {
+	mu        sync.RWMutex
+	records   map[Ref]Record
+	getRecord func(session *xenapi.Session, self Ref) (Record, error)
+}
+
+func newMirror[Ref ~string, Record any](getRecord func(session *xenapi.Session, self Ref) (Record, error)) *mirror[Ref, Record] {
+	return &mirror[Ref, Record]{records: make(map[Ref]Record), getRecord: getRecord}
+}
+
+// resync replaces the records with the snapshots of events, which hold every
+// object of the class.
+func (self *mirror[Ref, Record]) resync(session *xenapi.Session, events []xenapi.EventRecord) {
+	records := make(map[Ref]Record, len(events))
+	for _, event := range events {
+		record, err := self.record(session, event)
+		if err != nil {
+			log.Printf("object cache: %s %s: %s", event.Class, event.Ref, err)
+			continue
+		}
+		records[Ref(event.Ref)] = record
+	}
+	self.mu.Lock()
+	self.records = records
+	self.mu.Unlock()
+}
+
+func (self *mirror[Ref, Record]) apply(session *xenapi.Session, event xenapi.EventRecord) error {
+	ref := Ref(event.Ref)
+	if event.Operation == xenapi.EventOperationDel {
+		self.mu.Lock()
+		delete(self.records, ref)
+		self.mu.Unlock()
+		return nil
+	}
+	record, err := self.record(session, event)
+	if err != nil {
+		return err
+	}
+	self.mu.Lock()
+	self.records[ref] = record
+	self.mu.Unlock()
+	return nil
+}
+
+// record returns the snapshot of the event, falling back to get_record for
+// events that carry none.
+func (self *mirror[Ref, Record]) record(session *xenapi.Session, event xenapi.EventRecord) (Record, error) {
+	if record, ok := xenapi.EventSnapshot[Record](event); ok {
+		return record, nil
+	}
+	return self.getRecord(session, Ref(event.Ref))
+}
+
+// snapshot returns a copy of the mirrored records that the caller may keep.
+func (self *mirror[Ref, Record]) snapshot() map[Ref]Record {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	records := make(map[Ref]Record, len(self.records))
+	for ref, record := range self.records {
+		records[ref] = record
+	}
+	return records
+}
+
+// ObjectCache keeps an in-memory mirror of the XAPI objects most collectors
+// need. The objects are kept current by following event.from, so a scrape
+// does not cost any XAPI call for these classes.
+type ObjectCache struct {
+	xen     *SammXen
+	classes map[string]mirroredClass
+	mu      sync.RWMutex
+	synced  bool
+}
+
+func NewObjectCache(xen *SammXen) *ObjectCache {
+	return &ObjectCache{
+		xen: xen,
+		// Keyed by the lower case class name used in events
+		classes: map[string]mirroredClass{
+			"pool":             newMirror(xenapi.Pool.GetRecord),
+			"host":             newMirror(xenapi.Host.GetRecord),
+			"host_metrics":     newMirror(xenapi.HostMetrics.GetRecord),
+			"host_cpu":         newMirror(xenapi.HostCPU.GetRecord),
+			"vm":               newMirror(xenapi.VM.GetRecord),
+			"vm_metrics":       newMirror(xenapi.VMMetrics.GetRecord),
+			"vm_guest_metrics": newMirror(xenapi.VMGuestMetrics.GetRecord),
+			"sr":               newMirror(xenapi.SR.GetRecord),
+			"vdi":              newMirror(xenapi.VDI.GetRecord),
+			"network":          newMirror(xenapi.Network.GetRecord),
+			"pif":              newMirror(xenapi.PIF.GetRecord),
+			"pif_metrics":      newMirror(xenapi.PIFMetrics.GetRecord),
+		},
+	}
+}
+
+// Synced reports whether the mirror holds a current copy of the objects.
+func (self *ObjectCache) Synced() bool {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.synced
+}
+
+func (self *ObjectCache) setSynced(synced bool) {
+	self.mu.Lock()
+	self.synced = synced
+	self.mu.Unlock()
+}
+
+// Run follows the events until ctx is cancelled. While the watcher recovers
+// from an error the cache is not synced and reads go to XAPI directly.
+func (self *ObjectCache) Run(ctx context.Context) {
+	classes := make([]string, 0, len(self.classes))
+	for name := range self.classes {
+		classes = append(classes, name)
+	}
+	sort.Strings(classes)
+
+	watcher := xenapi.NewEventWatcher(self.xen.Session, &xenapi.EventWatcherOpts{
+		Classes:       classes,
+		Timeout:       eventFromTimeout,
+		RetryInterval: cacheRetryInterval,
+		OnError: func(err error) {
+			self.setSynced(false)
+			log.Printf("object cache: %s", err)
+		},
+	})
+	for batch := range watcher.Watch(ctx) {
+		if batch.Resync {
+			self.resync(batch.Events)
+		} else {
+			self.apply(batch.Events)
+		}
+		self.setSynced(true)
+	}
+	self.setSynced(false)
+}
+
+func (self *ObjectCache) resync(events []xenapi.EventRecord) {
+	byClass := make(map[string][]xenapi.EventRecord, len(self.classes))
+	for _, event := range events {
+		class := strings.ToLower(event.Class)
+		byClass[class] = append(byClass[class], event)
+	}
+	for name, class := range self.classes {
+		class.resync(self.xen.Session, byClass[name])
+	}
+}
+
+func (self *ObjectCache) apply(events []xenapi.EventRecord) {
+	for _, event := range events {
+		class, ok := self.classes[strings.ToLower(event.Class)]
+		if !ok {
+			continue
+		}
+		// The object may have been destroyed since; its del event follows.
+		if err := class.apply(self.xen.Session, event); err != nil && !errors.Is(err, xenapi.ErrHandleInvalid) {
+			log.Printf("object cache: %s %s: %s", event.Class, event.Ref, err)
+		}
+	}
+}
+
+// cachedRecords returns the records of class from cache when it is synced,
+// and from get_all_records otherwise.
+func cachedRecords[Ref ~string, Record any](cache *ObjectCache, class string, session *xenapi.Session, getAll func(session *xenapi.Session) (map[Ref]Record, error)) (map[Ref]Record, error) {
+	if cache != nil && cache.Synced() {
+		if m, ok := cache.classes[class].(*mirror[Ref, Record]); ok {
+			return m.snapshot(), nil
+		}
+	}
+	records, err := getAll(session)
+	if err != nil {
+		return nil, fmt.Errorf("%s.get_all_records: %w", class, err)
+	}
+	return records, nil
+}
+
+func (self SammXen) Pools() (map[xenapi.PoolRef]xenapi.PoolRecord, error) {
+	return cachedRecords(self.Cache, "pool", self.Session, xenapi.Pool.GetAllRecords)
+}
+
+func (self SammXen) Hosts() (map[xenapi.HostRef]xenapi.HostRecord, error) {
+	return cachedRecords(self.Cache, "host", self.Session, xenapi.Host.GetAllRecords)
+}
+
+func (self SammXen) HostMetrics() (map[xenapi.HostMetricsRef]xenapi.HostMetricsRecord, error) {
+	return cachedRecords(self.Cache, "host_metrics", self.Session, xenapi.HostMetrics.GetAllRecords)
+}
+
+func (self SammXen) HostCPUs() (map[xenapi.HostCPURef]xenapi.HostCPURecord, error) {
+	return cachedRecords(self.Cache, "host_cpu", self.Session, xenapi.HostCPU.GetAllRecords)
+}
+
+func (self SammXen) VMs() (map[xenapi.VMRef]xenapi.VMRecord, error) {
+	return cachedRecords(self.Cache, "vm", self.Session, xenapi.VM.GetAllRecords)
+}
+
+func (self SammXen) VMMetrics() (map[xenapi.VMMetricsRef]xenapi.VMMetricsRecord, error) {
+	return cachedRecords(self.Cache, "vm_metrics", self.Session, xenapi.VMMetrics.GetAllRecords)
+}
+
+func (self SammXen) VMGuestMetrics() (map[xenapi.VMGuestMetricsRef]xenapi.VMGuestMetricsRecord, error) {
+	return cachedRecords(self.Cache, "vm_guest_metrics", self.Session, xenapi.VMGuestMetrics.GetAllRecords)
+}
+
+func (self SammXen) SRs() (map[xenapi.SRRef]xenapi.SRRecord, error) {
+	return cachedRecords(self.Cache, "sr", self.Session, xenapi.SR.GetAllRecords)
+}
+
+func (self SammXen) VDIs() (map[xenapi.VDIRef]xenapi.VDIRecord, error) {
+	return cachedRecords(self.Cache, "vdi", self.Session, xenapi.VDI.GetAllRecords)
+}
+
+func (self SammXen) Networks() (map[xenapi.NetworkRef]xenapi.NetworkRecord, error) {
+	return cachedRecords(self.Cache, "network", self.Session, xenapi.Network.GetAllRecords)
+}
+
+func (self SammXen) PIFs() (map[xenapi.PIFRef]xenapi.PIFRecord, error) {
+	return cachedRecords(self.Cache, "pif", self.Session, xenapi.PIF.GetAllRecords)
+}
+
+func (self SammXen) PIFMetrics() (map[xenapi.PIFMetricsRef]xenapi.PIFMetricsRecord, error) {
+	return cachedRecords(self.Cache, "pif_metrics", self.Session, xenapi.PIFMetrics.GetAllRecords)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("certificate", NewCertificateCollector)
+}
+
+// CertificateCollector reports the validity period of the host, internal and
+// CA certificates of the pool, so that expiry can be alerted on at any
+// horizon rather than at the fixed thresholds of the XAPI messages.
+type CertificateCollector struct {
+	xen       *SammXen
+	notBefore *prometheus.Desc
+	notAfter  *prometheus.Desc
+	poolCA    *prometheus.Desc
+	poolCRL   *prometheus.Desc
+}
+
+func NewCertificateCollector(xen *SammXen) Collector {
+	labels := []string{"type", "host", "name", "fingerprint_sha256"}
+	return &CertificateCollector{
+		xen: xen,
+		notBefore: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "certificate", "not_before_timestamp_seconds"),
+			"Time after which the certificate is valid.",
+			labels, nil,
+		),
+		notAfter: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "certificate", "not_after_timestamp_seconds"),
+			"Time before which the certificate is valid.",
+			labels, nil,
+		),
+		poolCA: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "ca_certificate_info"),
+			"CA certificate installed with pool.certificate_install. Its validity is reported by the certificate metrics with type=\"ca\" and the same name.",
+			[]string{"name"}, nil,
+		),
+		poolCRL: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "crl_info"),
+			"Certificate revocation list installed with pool.crl_install.",
+			[]string{"name"}, nil,
+		),
+	}
+}
+
+func (self *CertificateCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+	certificates, err := xenapi.Certificate.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("Certificate.get_all_records: %w", err)
+	}
+	cas, err := xenapi.Pool.CertificateList(xen.Session)
+	if err != nil {
+		return fmt.Errorf("pool.certificate_list: %w", err)
+	}
+	crls, err := xenapi.Pool.CrlList(xen.Session)
+	if err != nil {
+		return fmt.Errorf("pool.crl_list: %w", err)
+	}
+
+	for _, certificate := range certificates {
+		labels := []string{string(certificate.Type), hosts[certificate.Host].NameLabel, certificate.Name, certificate.FingerprintSha256}
+		ch <- prometheus.MustNewConstMetric(self.notBefore, prometheus.GaugeValue, float64(certificate.NotBefore.Unix()), labels...)
+		ch <- prometheus.MustNewConstMetric(self.notAfter, prometheus.GaugeValue, float64(certificate.NotAfter.Unix()), labels...)
+	}
+	for _, name := range cas {
+		ch <- prometheus.MustNewConstMetric(self.poolCA, prometheus.GaugeValue, 1, name)
+	}
+	for _, name := range crls {
+		ch <- prometheus.MustNewConstMetric(self.poolCRL, prometheus.GaugeValue, 1, name)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("cluster", NewClusterCollector)
+}
+
+// ClusterCollector reports the quorum state of the corosync cluster backing
+// clustered SRs such as GFS2, and the membership of every host in it. Pools
+// without a cluster export nothing.
+type ClusterCollector struct {
+	xen          *SammXen
+	info         *prometheus.Desc
+	quorate      *prometheus.Desc
+	quorum       *prometheus.Desc
+	liveHosts    *prometheus.Desc
+	tokenTimeout *prometheus.Desc
+	hostJoined   *prometheus.Desc
+	hostLive     *prometheus.Desc
+	hostEnabled  *prometheus.Desc
+	hostLastLive *prometheus.Desc
+}
+
+func NewClusterCollector(xen *SammXen) Collector {
+	labels := []string{"cluster_uuid"}
+	hostLabels := []string{"cluster_uuid", "host_uuid", "host"}
+	return &ClusterCollector{
+		xen: xen,
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster", "info"),
+			"Cluster stack of the cluster.",
+			append(labels, "cluster_stack", "cluster_stack_version"), nil,
+		),
+		quorate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster", "quorate"),
+			"Whether the cluster has quorum.",
+			labels, nil,
+		),
+		quorum: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster", "quorum"),
+			"Number of live hosts needed for the cluster to be quorate.",
+			labels, nil,
+		),
+		liveHosts: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster", "live_hosts"),
+			"Number of live hosts in the cluster.",
+			labels, nil,
+		),
+		tokenTimeout: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster", "token_timeout_seconds"),
+			"Corosync token timeout of the cluster.",
+			labels, nil,
+		),
+		hostJoined: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster_host", "joined"),
+			"Whether the host has joined the cluster.",
+			hostLabels, nil,
+		),
+		hostLive: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster_host", "live"),
+			"Whether the host is considered live by the cluster.",
+			hostLabels, nil,
+		),
+		hostEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster_host", "enabled"),
+			"Whether clustering is enabled on the host.",
+			hostLabels, nil,
+		),
+		hostLastLive: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "cluster_host", "last_update_live_timestamp_seconds"),
+			"Time at which the liveness of the host was last updated.",
+			hostLabels, nil,
+		),
+	}
+}
+
+func (self *ClusterCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	clusters, err := xenapi.Cluster.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("Cluster.get_all_records: %w", err)
+	}
+	if len(clusters) == 0 {
+		return nil
+	}
+	clusterHosts, err := xenapi.ClusterHost.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("Cluster_host.get_all_records: %w", err)
+	}
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters {
+		ch <- prometheus.MustNewConstMetric(self.info, prometheus.GaugeValue, 1, cluster.UUID, cluster.ClusterStack, strconv.Itoa(cluster.ClusterStackVersion))
+		ch <- prometheus.MustNewConstMetric(self.quorate, prometheus.GaugeValue, boolToFloat(cluster.IsQuorate), cluster.UUID)
+		ch <- prometheus.MustNewConstMetric(self.quorum, prometheus.GaugeValue, float64(cluster.Quorum), cluster.UUID)
+		ch <- prometheus.MustNewConstMetric(self.liveHosts, prometheus.GaugeValue, float64(cluster.LiveHosts), cluster.UUID)
+		ch <- prometheus.MustNewConstMetric(self.tokenTimeout, prometheus.GaugeValue, cluster.TokenTimeout, cluster.UUID)
+	}
+	for _, clusterHost := range clusterHosts {
+		cluster, ok := clusters[clusterHost.Cluster]
+		if !ok {
+			continue
+		}
+		host := hosts[clusterHost.Host]
+		labels := []string{cluster.UUID, host.UUID, host.NameLabel}
+		ch <- prometheus.MustNewConstMetric(self.hostJoined, prometheus.GaugeValue, boolToFloat(clusterHost.Joined), labels...)
+		ch <- prometheus.MustNewConstMetric(self.hostLive, prometheus.GaugeValue, boolToFloat(clusterHost.Live), labels...)
+		ch <- prometheus.MustNewConstMetric(self.hostEnabled, prometheus.GaugeValue, boolToFloat(clusterHost.Enabled), labels...)
+		if !clusterHost.LastUpdateLive.IsZero() {
+			ch <- prometheus.MustNewConstMetric(self.hostLastLive, prometheus.GaugeValue, float64(clusterHost.LastUpdateLive.Unix()), labels...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "xen"
+
+// Collector is implemented by every XenServer sub-collector. Update is called
+// once per scrape and sends the collected metrics on ch. XAPI calls made with
+// ctx are aborted when the scrape times out.
+type Collector interface {
+	Update(ctx context.Context, ch chan<- prometheus.Metric) error
+}
+
+var (
+	factories   = map[string]func(xen *SammXen) Collector{}
+	factoriesMu sync.Mutex
+)
+
+// registerCollector makes a sub-collector available under name. It is meant to
+// be called from the init function of the file implementing the collector.
+func registerCollector(name string, factory func(xen *SammXen) Collector) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// availableCollectors returns the sorted names of all registered collectors.
+func availableCollectors() []string {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var (
+	scrapeDurationDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_duration_seconds"),
+		"Duration of a collector scrape.",
+		[]string{"collector"}, nil,
+	)
+	scrapeSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_success"),
+		"Whether a collector succeeded.",
+		[]string{"collector"}, nil,
+	)
+)
+
+// XenCollector implements prometheus.Collector and runs the enabled
+// sub-collectors against a single pool on every scrape.
+type XenCollector struct {
+	xen        *SammXen
+	collectors map[string]Collector
+}
+
+// checkCollectors returns an error if a name is not a registered collector.
+func checkCollectors(names []string) error {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	for _, name := range names {
+		if _, ok := factories[name]; !ok {
+			return fmt.Errorf("unknown collector %q", name)
+		}
+	}
+	return nil
+}
+
+func NewXenCollector(xen *SammXen, names []string) (*XenCollector, error) {
+	c := &XenCollector{
+		xen:        xen,
+		collectors: make(map[string]Collector),
+	}
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	for _, name := range names {
+		factory, ok := factories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown collector %q", name)
+		}
+		c.collectors[name] = factory(xen)
+	}
+	return c, nil
+}
+
+func (self *XenCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- scrapeDurationDesc
+	ch <- scrapeSuccessDesc
+}
+
+func (self *XenCollector) Collect(ch chan<- prometheus.Metric) {
+	self.collect(context.Background(), ch)
+}
+
+// WithContext returns a prometheus.Collector running the sub-collectors with
+// ctx, typically bound to a single scrape request.
+func (self *XenCollector) WithContext(ctx context.Context) prometheus.Collector {
+	return &scrapeCollector{XenCollector: self, ctx: ctx}
+}
+
+func (self *XenCollector) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	var wg sync.WaitGroup
+	wg.Add(len(self.collectors))
+	for name, c := range self.collectors {
+		go func(name string, c Collector) {
+			execute(ctx, name, c, ch)
+			wg.Done()
+		}(name, c)
+	}
+	wg.Wait()
+}
+
+type scrapeCollector struct {
+	*XenCollector
+	ctx context.Context
+}
+
+func (self *scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	self.collect(self.ctx, ch)
+}
+
+func execute(ctx context.Context, name string, c Collector, ch chan<- prometheus.Metric) {
+	begin := time.Now()
+	err := c.Update(ctx, ch)
+	duration := time.Since(begin)
+	success := 1.0
+	if err != nil {
+		log.Printf("collector %s failed after %v: %s", name, duration, err)
+		success = 0
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
+	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
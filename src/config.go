@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"xenapi"
+)
+
+const defaultPoolInterval = time.Minute
+
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Config is the configuration file listing the pools to monitor, e.g.
+//
+//	credentials:
+//	  prod:
+//	    username: root
+//	    password_file: /etc/xen-exporter/prod.password
+//	pools:
+//	  - name: prod-a
+//	    members: [10.0.0.1, 10.0.0.2]
+//	    credentials: prod
+//	    ca_file: /etc/xen-exporter/prod-ca.pem
+//	    collectors: [pool, host, sr]
+//	    interval: 30s
+//	    labels:
+//	      datacenter: par1
+//	modules:
+//	  default:
+//	    credentials: prod
+//	    collectors: [pool, host, vm_perf]
+//	    targets: [10.0.1.1, 10.0.2.0/24]
+//
+// Pools are collected in the background and served on /metrics, modules are
+// used by /probe to collect a target at scrape time. A target is the name of
+// a pool or the address of a host. The credentials of a module are sent to
+// any address Prometheus asks for, unless the module lists the addresses or
+// CIDR ranges it may probe in targets.
+type Config struct {
+	Credentials map[string]CredentialsConfig `yaml:"credentials"`
+	Pools       []PoolConfig                 `yaml:"pools"`
+	Modules     map[string]ModuleConfig      `yaml:"modules"`
+}
+
+// CredentialsConfig is a XenAPI user, referenced by name from the pools.
+type CredentialsConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// PasswordFile keeps the password out of the configuration file
+	PasswordFile string `yaml:"password_file"`
+}
+
+// PoolConfig is a pool to monitor.
+type PoolConfig struct {
+	// Name is the value of the pool label of its metrics
+	Name string `yaml:"name"`
+	// Address of the coordinator, or of any member, which redirects to it
+	Address string `yaml:"address"`
+	// Members are tried in turn when Address is not set or does not answer
+	Members     []string `yaml:"members"`
+	Credentials string   `yaml:"credentials"`
+	TLSFiles    `yaml:",inline"`
+	// Collectors enabled for the pool, all of them if unset
+	Collectors []string `yaml:"collectors"`
+	// Interval between two collections of the pool
+	Interval time.Duration `yaml:"interval"`
+	// Labels added to every metric of the pool
+	Labels map[string]string `yaml:"labels"`
+}
+
+// ModuleConfig is a way of collecting the targets of /probe.
+type ModuleConfig struct {
+	Credentials string `yaml:"credentials"`
+	TLSFiles    `yaml:",inline"`
+	// Collectors enabled for the targets, all of them if unset
+	Collectors []string `yaml:"collectors"`
+	// Targets restrict the addresses the module may probe besides the pools
+	// to these addresses and CIDR ranges, if set
+	Targets []string `yaml:"targets"`
+	// Ranges of Targets, parsed by check
+	prefixes []netip.Prefix
+}
+
+// TLSFiles are the certificates used to connect to a pool.
+type TLSFiles struct {
+	// CAFile verifies the certificate of the pool; it is not verified if unset
+	CAFile         string `yaml:"ca_file"`
+	ClientCertFile string `yaml:"client_cert_file"`
+	ClientKeyFile  string `yaml:"client_key_file"`
+}
+
+// LoadConfig reads and checks the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := config.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &config, nil
+}
+
+func (self *Config) check() error {
+	if len(self.Pools) == 0 && len(self.Modules) == 0 {
+		return errors.New("neither pools nor modules configured")
+	}
+	for name, credentials := range self.Credentials {
+		if credentials.Username == "" {
+			return fmt.Errorf("credentials %q: no username", name)
+		}
+		if credentials.Password != "" && credentials.PasswordFile != "" {
+			return fmt.Errorf("credentials %q: both password and password_file are set", name)
+		}
+	}
+	names := make(map[string]bool, len(self.Pools))
+	for i := range self.Pools {
+		pool := &self.Pools[i]
+		if pool.Name == "" {
+			return fmt.Errorf("pool %d: no name", i)
+		}
+		if names[pool.Name] {
+			return fmt.Errorf("pool %q: configured twice", pool.Name)
+		}
+		names[pool.Name] = true
+		if err := pool.check(self.Credentials); err != nil {
+			return fmt.Errorf("pool %q: %w", pool.Name, err)
+		}
+	}
+	for name, module := range self.Modules {
+		if err := module.check(self.Credentials); err != nil {
+			return fmt.Errorf("module %q: %w", name, err)
+		}
+		self.Modules[name] = module
+	}
+	return nil
+}
+
+func (self *PoolConfig) check(credentials map[string]CredentialsConfig) error {
+	if self.Address == "" && len(self.Members) == 0 {
+		return errors.New("neither address nor members is set")
+	}
+	if _, ok := credentials[self.Credentials]; !ok {
+		return fmt.Errorf("unknown credentials %q", self.Credentials)
+	}
+	if err := self.TLSFiles.check(); err != nil {
+		return err
+	}
+	if len(self.Collectors) == 0 {
+		self.Collectors = availableCollectors()
+	}
+	if err := checkCollectors(self.Collectors); err != nil {
+		return err
+	}
+	if self.Interval == 0 {
+		self.Interval = defaultPoolInterval
+	}
+	if self.Interval < 0 {
+		return fmt.Errorf("negative interval %v", self.Interval)
+	}
+	for name := range self.Labels {
+		if !labelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") || name == "pool" {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+func (self *ModuleConfig) check(credentials map[string]CredentialsConfig) error {
+	if _, ok := credentials[self.Credentials]; !ok {
+		return fmt.Errorf("unknown credentials %q", self.Credentials)
+	}
+	if err := self.TLSFiles.check(); err != nil {
+		return err
+	}
+	if len(self.Collectors) == 0 {
+		self.Collectors = availableCollectors()
+	}
+	if err := checkCollectors(self.Collectors); err != nil {
+		return err
+	}
+	self.prefixes = nil
+	for _, target := range self.Targets {
+		if !strings.Contains(target, "/") {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(target)
+		if err != nil {
+			return fmt.Errorf("invalid target range: %w", err)
+		}
+		self.prefixes = append(self.prefixes, prefix)
+	}
+	return nil
+}
+
+// allows returns whether the module may probe the host at address, which may
+// carry a port.
+func (self ModuleConfig) allows(address string) bool {
+	if len(self.Targets) == 0 || slices.Contains(self.Targets, address) {
+		return true
+	}
+	host := address
+	if h, _, err := net.SplitHostPort(address); err == nil {
+		host = h
+	}
+	if slices.Contains(self.Targets, host) {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range self.prefixes {
+		if prefix.Contains(ip.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// check reports unusable certificates at startup rather than on every login.
+func (self TLSFiles) check() error {
+	if self.CAFile != "" {
+		pem, err := os.ReadFile(self.CAFile)
+		if err != nil {
+			return err
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no PEM certificate found", self.CAFile)
+		}
+	}
+	if self.ClientCertFile != "" || self.ClientKeyFile != "" {
+		if _, err := tls.LoadX509KeyPair(self.ClientCertFile, self.ClientKeyFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addresses returns the addresses to log in to, in order.
+func (self PoolConfig) addresses() []string {
+	if self.Address == "" {
+		return self.Members
+	}
+	return append([]string{self.Address}, self.Members...)
+}
+
+func (self TLSFiles) secureOpts() *xenapi.SecureOpts {
+	if self.CAFile == "" && self.ClientCertFile == "" && self.ClientKeyFile == "" {
+		return nil
+	}
+	return &xenapi.SecureOpts{
+		ServerCert: self.CAFile,
+		ClientCert: self.ClientCertFile,
+		ClientKey:  self.ClientKeyFile,
+	}
+}
+
+// password returns the password, read from PasswordFile if set.
+func (self CredentialsConfig) password() (string, error) {
+	if self.PasswordFile == "" {
+		return self.Password, nil
+	}
+	password, err := os.ReadFile(self.PasswordFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(password), "\r\n"), nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validConfig returns a configuration with a pool and a module that check
+// accepts.
+func validConfig() Config {
+	return Config{
+		Credentials: map[string]CredentialsConfig{
+			"prod": {Username: "root", Password: "secret"},
+		},
+		Pools: []PoolConfig{
+			{Name: "prod-a", Members: []string{"10.0.0.1"}, Credentials: "prod"},
+		},
+		Modules: map[string]ModuleConfig{
+			"default": {Credentials: "prod"},
+		},
+	}
+}
+
+func TestConfigCheck(t *testing.T) {
+	config := validConfig()
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+	pool := config.Pools[0]
+	if pool.Interval != defaultPoolInterval {
+		t.Fatalf("expected the default interval, got %v", pool.Interval)
+	}
+	if len(pool.Collectors) == 0 || len(config.Modules["default"].Collectors) != len(pool.Collectors) {
+		t.Fatalf("expected every collector by default, got %v and %v", pool.Collectors, config.Modules["default"].Collectors)
+	}
+
+	tests := map[string]struct {
+		change func(config *Config)
+		err    string
+	}{
+		"nothing configured": {func(config *Config) {
+			config.Pools, config.Modules = nil, nil
+		}, "neither pools nor modules"},
+		"no username": {func(config *Config) {
+			config.Credentials["other"] = CredentialsConfig{Password: "secret"}
+		}, "no username"},
+		"two passwords": {func(config *Config) {
+			config.Credentials["other"] = CredentialsConfig{Username: "root", Password: "secret", PasswordFile: "/secret"}
+		}, "both password and password_file"},
+		"no pool name": {func(config *Config) {
+			config.Pools[0].Name = ""
+		}, "no name"},
+		"duplicate pool": {func(config *Config) {
+			config.Pools = append(config.Pools, config.Pools[0])
+		}, "configured twice"},
+		"no pool address": {func(config *Config) {
+			config.Pools[0].Members = nil
+		}, "neither address nor members"},
+		"unknown pool credentials": {func(config *Config) {
+			config.Pools[0].Credentials = "test"
+		}, `unknown credentials "test"`},
+		"unknown module credentials": {func(config *Config) {
+			config.Modules["default"] = ModuleConfig{Credentials: "test"}
+		}, `unknown credentials "test"`},
+		"unknown pool collector": {func(config *Config) {
+			config.Pools[0].Collectors = []string{"host", "nope"}
+		}, `unknown collector "nope"`},
+		"unknown module collector": {func(config *Config) {
+			config.Modules["default"] = ModuleConfig{Credentials: "prod", Collectors: []string{"nope"}}
+		}, `unknown collector "nope"`},
+		"negative interval": {func(config *Config) {
+			config.Pools[0].Interval = -time.Second
+		}, "negative interval"},
+		"invalid label": {func(config *Config) {
+			config.Pools[0].Labels = map[string]string{"data-center": "par1"}
+		}, "invalid label name"},
+		"reserved label": {func(config *Config) {
+			config.Pools[0].Labels = map[string]string{"__name__": "up"}
+		}, "invalid label name"},
+		"pool label": {func(config *Config) {
+			config.Pools[0].Labels = map[string]string{"pool": "other"}
+		}, "invalid label name"},
+		"invalid target range": {func(config *Config) {
+			config.Modules["default"] = ModuleConfig{Credentials: "prod", Targets: []string{"10.0.0.0/33"}}
+		}, "invalid target range"},
+		"missing CA file": {func(config *Config) {
+			config.Pools[0].CAFile = filepath.Join(t.TempDir(), "ca.pem")
+		}, "no such file"},
+		"invalid CA file": {func(config *Config) {
+			config.Pools[0].CAFile = writeFile(t, "ca.pem", "not a certificate")
+		}, "no PEM certificate found"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := validConfig()
+			test.change(&config)
+			err := config.check()
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestModuleAllows(t *testing.T) {
+	config := validConfig()
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+	if !config.Modules["default"].allows("10.9.9.9") {
+		t.Fatal("expected a module without targets to allow any address")
+	}
+
+	config.Modules["default"] = ModuleConfig{Credentials: "prod", Targets: []string{"xcp1.example.com", "10.0.1.1", "10.0.2.0/24", "fd00::/64"}}
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+	module := config.Modules["default"]
+	for address, allowed := range map[string]bool{
+		"xcp1.example.com":     true,
+		"xcp1.example.com:443": true,
+		"xcp2.example.com":     false,
+		"10.0.1.1":             true,
+		"10.0.1.2":             false,
+		"10.0.2.17:443":        true,
+		"10.0.3.17":            false,
+		"[fd00::1]:443":        true,
+		"fd01::1":              false,
+	} {
+		if module.allows(address) != allowed {
+			t.Errorf("expected allows(%q) to be %v", address, allowed)
+		}
+	}
+}
+
+func TestCredentialsPassword(t *testing.T) {
+	credentials := CredentialsConfig{Username: "root", Password: "secret"}
+	if password, err := credentials.password(); err != nil || password != "secret" {
+		t.Fatalf("expected the inline password, got %q, %v", password, err)
+	}
+
+	// Only the line ending is trimmed, not the spaces of the password
+	credentials = CredentialsConfig{Username: "root", PasswordFile: writeFile(t, "password", " secret \r\n")}
+	if password, err := credentials.password(); err != nil || password != " secret " {
+		t.Fatalf("expected the password of the file, got %q, %v", password, err)
+	}
+
+	credentials.PasswordFile = filepath.Join(t.TempDir(), "missing")
+	if _, err := credentials.password(); err == nil {
+		t.Fatal("expected a missing password file to fail")
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
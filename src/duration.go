@@ -1,10 +0,0 @@
-package main
-
-import (
-	"fmt"
-	"time"
-)
-
-func main() {
-	fmt.Printf("%v\n", time.Duration(75) * time.Millisecond)
-}
\ No newline at end of file
@@ -11,16 +11,24 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-// A simple example exposing fictional RPC latencies with different types of
-// random distributions (uniform, normal, and exponential) as Prometheus
-// metrics.
+// A Prometheus exporter for XenServer / XCP-ng pools. Metrics are gathered
+// through XenAPI on every scrape by the enabled collectors, or in the
+// background for each pool listed in the configuration file. With a
+// configuration file, /probe also collects the target of a scrape with the
+// collectors and credentials of a module.
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
-	"math"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -28,25 +36,6 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-type metrics struct {
-	sammGauge             *prometheus.GaugeVec
-}
-
-func NewMetrics(reg prometheus.Registerer) *metrics {
-	m := &metrics{
-		sammGauge: prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name:      "samm_gauge",
-				Help:      "This is a test metric for Gauge",
-			},
-			[]string{"instance"},
-		),
-
-	}
-	reg.MustRegister(m.sammGauge)
-	return m
-}
-
 func Log(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
@@ -54,19 +43,58 @@ func Log(handler http.Handler) http.Handler {
 	})
 }
 
+// scrapeHandler serves the metrics of xc along with those of reg. The XenAPI
+// calls of a scrape are aborted when the client goes away or shortly before
+// the timeout announced by Prometheus.
+func scrapeHandler(reg *prometheus.Registry, xc *XenCollector, timeoutOffset time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := scrapeContext(r, timeoutOffset)
+		defer cancel()
+		scrape := prometheus.NewRegistry()
+		scrape.MustRegister(xc.WithContext(ctx))
+		promhttp.HandlerFor(
+			prometheus.Gatherers{reg, scrape},
+			promhttp.HandlerOpts{
+				// Opt into OpenMetrics to support exemplars.
+				EnableOpenMetrics: true,
+				// Pass custom registry
+				Registry: reg,
+			},
+		).ServeHTTP(w, r)
+	})
+}
+
+func scrapeContext(r *http.Request, timeoutOffset time.Duration) (context.Context, context.CancelFunc) {
+	seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64)
+	if err != nil || seconds <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > timeoutOffset {
+		timeout -= timeoutOffset
+	}
+	return context.WithTimeout(r.Context(), timeout)
+}
+
 func main() {
 	var (
 		addr              = flag.String("listen-address", ":5000", "The address to listen on for HTTP requests.")
-		oscillationPeriod = flag.Duration("oscillation-period", 10*time.Minute, "The duration of the rate oscillation period.")
+		configFile        = flag.String("config.file", "", "YAML file listing the pools to monitor and the modules of /probe. The -host, -username, -password and -collectors flags are ignored when set.")
+		enabledCollectors = flag.String("collectors", strings.Join(availableCollectors(), ","), "Comma separated list of collectors to enable.")
+		timeoutOffset     = flag.Duration("timeout-offset", 500*time.Millisecond, "Offset to subtract from the Prometheus scrape timeout, so that the XenAPI calls of a scrape are aborted before Prometheus gives up.")
+		keepAlive         = flag.Duration("session.keepalive-interval", 5*time.Minute, "Interval between two checks that the XenAPI session is still valid, 0 to disable.")
+		probeIdleTimeout  = flag.Duration("probe.idle-timeout", 5*time.Minute, "Time after which the session of a /probe target that is not probed any more is logged out, 0 to keep it.")
+		useCache          = flag.Bool("cache", true, "Mirror the hosts, VMs, SRs and networks in memory through XenAPI events instead of fetching them on every scrape.")
 	)
 
 	flag.Parse()
 
-	// Create a non-global registry.
-	reg := prometheus.NewRegistry()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Create new metrics and register them using the custom registry.
-	m := NewMetrics(reg)
+	// Create a non-global registry for the collectors that do not depend on
+	// the pools.
+	reg := prometheus.NewRegistry()
 	// Add Go module build info.
 	reg.MustRegister(
 		collectors.NewBuildInfoCollector(),
@@ -74,33 +102,80 @@ func main() {
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
 
-	start := time.Now()
+	var (
+		background sync.WaitGroup
+		x          *SammXen
+		prober     *Prober
+	)
+	opts := poolOptions{useCache: *useCache, keepAlive: *keepAlive}
+	if *configFile != "" {
+		config, err := LoadConfig(*configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gatherers := prometheus.Gatherers{reg}
+		for _, poolConfig := range config.Pools {
+			pool := NewPool(poolConfig, config.Credentials[poolConfig.Credentials], opts)
+			gatherers = append(gatherers, pool)
+			background.Add(1)
+			go func() {
+				defer background.Done()
+				pool.Run(ctx)
+			}()
+		}
+		log.Printf("monitoring %d pools from %s", len(config.Pools), *configFile)
+
+		// Expose the last collection of every pool via HTTP.
+		http.Handle("/metrics", promhttp.HandlerFor(
+			gatherers,
+			promhttp.HandlerOpts{
+				EnableOpenMetrics: true,
+				Registry:          reg,
+			},
+		))
+		// Collect the targets chosen by Prometheus with the modules.
+		prober = NewProber(ctx, config, opts, *timeoutOffset, *probeIdleTimeout)
+		http.Handle("/probe", prober)
+	} else {
+		var err error
+		x, err = NewSammXen(*HOST_FLAG, *USERNAME_FLAG, *PASSWORD_FLAG, *VERIFYSSL_FLAG)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("logged in to %s, session %s", x.Session.URL(), x.SessionId())
+
+		opts.start(ctx, x, &background)
+
+		xc, err := NewXenCollector(x, strings.Split(*enabledCollectors, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	oscillationFactor := func() float64 {
-		return 2 + math.Sin(math.Sin(2*math.Pi*float64(time.Since(start))/float64(*oscillationPeriod)))
+		// Expose the registered metrics via HTTP.
+		http.Handle("/metrics", scrapeHandler(reg, xc, *timeoutOffset))
 	}
 
-	// Periodically record some sample latencies for the three services.
+	server := &http.Server{Addr: *addr, Handler: Log(http.DefaultServeMux)}
 	go func() {
-		v := 0.0
-		for {
-			v += 1
-			m.sammGauge.WithLabelValues("instance").Set(v)
-			m.sammGauge.WithLabelValues("oscillation").Set(oscillationFactor())
-			log.Printf(".")
-			time.Sleep(time.Duration(1) * time.Second)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
 		}
 	}()
 
-	// Expose the registered metrics via HTTP.
-	http.Handle("/metrics", promhttp.HandlerFor(
-		reg,
-		promhttp.HandlerOpts{
-			// Opt into OpenMetrics to support exemplars.
-			EnableOpenMetrics: true,
-			// Pass custom registry
-			Registry: reg,
-		},
-	))
-	log.Fatal(http.ListenAndServe(*addr, Log(http.DefaultServeMux)))
-}
\ No newline at end of file
+	<-ctx.Done()
+	log.Printf("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http server shutdown: %s", err)
+	}
+	background.Wait()
+	if prober != nil {
+		prober.Close()
+	}
+	if x != nil {
+		if err := x.Logout(); err != nil {
+			log.Printf("logout: %s", err)
+		}
+	}
+}
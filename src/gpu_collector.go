@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("gpu", NewGPUCollector)
+}
+
+var pgpuDom0AccessStates = []xenapi.PciDom0Access{
+	xenapi.PciDom0AccessEnabled,
+	xenapi.PciDom0AccessDisableOnReboot,
+	xenapi.PciDom0AccessDisabled,
+	xenapi.PciDom0AccessEnableOnReboot,
+}
+
+// GPUCollector reports how many vGPUs of each type the physical GPUs and GPU
+// groups can still host, and which VM every vGPU belongs to.
+type GPUCollector struct {
+	xen               *SammXen
+	pgpuCapacity      *prometheus.Desc
+	pgpuResident      *prometheus.Desc
+	pgpuDom0Access    *prometheus.Desc
+	pgpuSystemDisplay *prometheus.Desc
+	groupRemaining    *prometheus.Desc
+	vgpuInfo          *prometheus.Desc
+}
+
+func NewGPUCollector(xen *SammXen) Collector {
+	pgpuLabels := []string{"host", "pgpu_uuid", "gpu_group"}
+	return &GPUCollector{
+		xen: xen,
+		pgpuCapacity: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pgpu", "vgpu_capacity"),
+			"Maximum number of vGPUs of a type the physical GPU can host.",
+			append(pgpuLabels, "vgpu_type"), nil,
+		),
+		pgpuResident: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pgpu", "resident_vgpus"),
+			"Number of vGPUs of a type running on the physical GPU.",
+			append(pgpuLabels, "vgpu_type"), nil,
+		),
+		pgpuDom0Access: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pgpu", "dom0_access"),
+			"Access of the control domain to the physical GPU, 1 for the current state.",
+			append(pgpuLabels, "state"), nil,
+		),
+		pgpuSystemDisplay: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pgpu", "is_system_display_device"),
+			"Whether the physical GPU is the system display device of the host.",
+			pgpuLabels, nil,
+		),
+		groupRemaining: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "gpu_group", "remaining_capacity"),
+			"Number of additional vGPUs of a type the GPU group can host.",
+			[]string{"gpu_group_uuid", "gpu_group", "vgpu_type"}, nil,
+		),
+		vgpuInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vgpu", "info"),
+			"Placement of a vGPU: the VM it belongs to and the physical GPU it runs on.",
+			[]string{"vgpu_uuid", "vgpu_type", "vm_uuid", "vm", "gpu_group", "pgpu_uuid", "host"}, nil,
+		),
+	}
+}
+
+func (self *GPUCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	pgpus, err := xenapi.PGPU.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("PGPU.get_all_records: %w", err)
+	}
+	if len(pgpus) == 0 {
+		return nil
+	}
+	groups, err := xenapi.GPUGroup.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("GPU_group.get_all_records: %w", err)
+	}
+	vgpus, err := xenapi.VGPU.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("VGPU.get_all_records: %w", err)
+	}
+	types, err := xenapi.VGPUType.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("VGPU_type.get_all_records: %w", err)
+	}
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+	vms, err := xen.VMs()
+	if err != nil {
+		return err
+	}
+
+	for _, pgpu := range pgpus {
+		labels := []string{hosts[pgpu.Host].NameLabel, pgpu.UUID, groups[pgpu.GPUGroup].NameLabel}
+		resident := make(map[xenapi.VGPUTypeRef]int)
+		for _, ref := range pgpu.ResidentVGPUs {
+			resident[vgpus[ref].Type]++
+		}
+		for typeRef, capacity := range pgpu.SupportedVGPUMaxCapacities {
+			typeName := types[typeRef].ModelName
+			ch <- prometheus.MustNewConstMetric(self.pgpuCapacity, prometheus.GaugeValue, float64(capacity), append(labels, typeName)...)
+			ch <- prometheus.MustNewConstMetric(self.pgpuResident, prometheus.GaugeValue, float64(resident[typeRef]), append(labels, typeName)...)
+		}
+		for _, state := range pgpuDom0AccessStates {
+			ch <- prometheus.MustNewConstMetric(self.pgpuDom0Access, prometheus.GaugeValue, boolToFloat(pgpu.Dom0Access == state), append(labels, string(state))...)
+		}
+		ch <- prometheus.MustNewConstMetric(self.pgpuSystemDisplay, prometheus.GaugeValue, boolToFloat(pgpu.IsSystemDisplayDevice), labels...)
+	}
+
+	for groupRef, group := range groups {
+		for _, typeRef := range group.EnabledVGPUTypes {
+			remaining, err := xenapi.GPUGroup.GetRemainingCapacity(xen.Session, groupRef, typeRef)
+			// Destroyed since the records were read
+			if errors.Is(err, xenapi.ErrHandleInvalid) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("GPU_group.get_remaining_capacity: %w", err)
+			}
+			ch <- prometheus.MustNewConstMetric(self.groupRemaining, prometheus.GaugeValue, float64(remaining), group.UUID, group.NameLabel, types[typeRef].ModelName)
+		}
+	}
+
+	for _, vgpu := range vgpus {
+		vm := vms[vgpu.VM]
+		pgpu := pgpus[vgpu.ResidentOn]
+		ch <- prometheus.MustNewConstMetric(self.vgpuInfo, prometheus.GaugeValue, 1,
+			vgpu.UUID, types[vgpu.Type].ModelName, vm.UUID, vm.NameLabel,
+			groups[vgpu.GPUGroup].NameLabel, pgpu.UUID, hosts[pgpu.Host].NameLabel)
+	}
+	return nil
+}
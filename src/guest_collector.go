@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("guest", NewGuestCollector)
+}
+
+// GuestCollector reports the state of the guest agent and PV drivers of every
+// VM from its VM_guest_metrics object.
+type GuestCollector struct {
+	xen              *SammXen
+	info             *prometheus.Desc
+	live             *prometheus.Desc
+	driversUpToDate  *prometheus.Desc
+	driversDetected  *prometheus.Desc
+	canUseHotplugVbd *prometheus.Desc
+	canUseHotplugVif *prometheus.Desc
+	guestLastUpdated *prometheus.Desc
+}
+
+func NewGuestCollector(xen *SammXen) Collector {
+	labels := []string{"vm_uuid", "vm"}
+	return &GuestCollector{
+		xen: xen,
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm_guest", "info"),
+			"Operating system and PV driver versions reported by the guest agent.",
+			append(labels, "distro", "os_name", "os_major", "os_minor", "pv_drivers_version"), nil,
+		),
+		live: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm_guest", "live"),
+			"Whether the guest agent is sending heartbeats.",
+			labels, nil,
+		),
+		driversUpToDate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm_guest", "pv_drivers_up_to_date"),
+			"Whether the PV drivers of the guest are up to date.",
+			labels, nil,
+		),
+		driversDetected: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm_guest", "pv_drivers_detected"),
+			"Whether at least one device of the guest connected to its PV backend.",
+			labels, nil,
+		),
+		canUseHotplugVbd: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm_guest", "can_use_hotplug_vbd"),
+			"Whether the guest supports VBD hotplug. Absent if the guest did not say.",
+			labels, nil,
+		),
+		canUseHotplugVif: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm_guest", "can_use_hotplug_vif"),
+			"Whether the guest supports VIF hotplug. Absent if the guest did not say.",
+			labels, nil,
+		),
+		guestLastUpdated: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm_guest", "last_updated_timestamp_seconds"),
+			"Time at which the guest metrics were last updated.",
+			labels, nil,
+		),
+	}
+}
+
+func (self *GuestCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	vms, err := xen.VMs()
+	if err != nil {
+		return err
+	}
+	guests, err := xen.VMGuestMetrics()
+	if err != nil {
+		return err
+	}
+
+	for _, vm := range vms {
+		if vm.IsATemplate || vm.IsASnapshot || vm.IsControlDomain {
+			continue
+		}
+		guest, ok := guests[vm.GuestMetrics]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(self.info, prometheus.GaugeValue, 1,
+			vm.UUID, vm.NameLabel,
+			guest.OsVersion["distro"], guest.OsVersion["name"], guest.OsVersion["major"], guest.OsVersion["minor"],
+			pvDriversVersion(guest.PVDriversVersion))
+		ch <- prometheus.MustNewConstMetric(self.live, prometheus.GaugeValue, boolToFloat(guest.Live), vm.UUID, vm.NameLabel)
+		ch <- prometheus.MustNewConstMetric(self.driversUpToDate, prometheus.GaugeValue, boolToFloat(guest.PVDriversUpToDate), vm.UUID, vm.NameLabel)
+		ch <- prometheus.MustNewConstMetric(self.driversDetected, prometheus.GaugeValue, boolToFloat(guest.PVDriversDetected), vm.UUID, vm.NameLabel)
+		if guest.CanUseHotplugVbd == xenapi.TristateTypeYes || guest.CanUseHotplugVbd == xenapi.TristateTypeNo {
+			ch <- prometheus.MustNewConstMetric(self.canUseHotplugVbd, prometheus.GaugeValue, boolToFloat(guest.CanUseHotplugVbd == xenapi.TristateTypeYes), vm.UUID, vm.NameLabel)
+		}
+		if guest.CanUseHotplugVif == xenapi.TristateTypeYes || guest.CanUseHotplugVif == xenapi.TristateTypeNo {
+			ch <- prometheus.MustNewConstMetric(self.canUseHotplugVif, prometheus.GaugeValue, boolToFloat(guest.CanUseHotplugVif == xenapi.TristateTypeYes), vm.UUID, vm.NameLabel)
+		}
+		if !guest.LastUpdated.IsZero() {
+			ch <- prometheus.MustNewConstMetric(self.guestLastUpdated, prometheus.GaugeValue, float64(guest.LastUpdated.Unix()), vm.UUID, vm.NameLabel)
+		}
+	}
+	return nil
+}
+
+// pvDriversVersion formats the PV_drivers_version map as major.minor.micro-build.
+func pvDriversVersion(version map[string]string) string {
+	if version["major"] == "" {
+		return ""
+	}
+	s := fmt.Sprintf("%s.%s.%s", version["major"], version["minor"], version["micro"])
+	if version["build"] != "" {
+		s += "-" + version["build"]
+	}
+	return s
+}
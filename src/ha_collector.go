@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+var haComputeInterval = flag.Duration("collector.ha.compute-interval", 5*time.Minute, "How often to run pool.ha_compute_max_host_failures_to_tolerate.")
+
+func init() {
+	registerCollector("ha", NewHACollector)
+}
+
+// HACollector reports the high availability configuration of the pool and
+// how many host failures the current HA plan can survive.
+type HACollector struct {
+	xen *SammXen
+	mu  sync.Mutex
+	// Result of the last pool.ha_compute_max_host_failures_to_tolerate call,
+	// which is not repeated before the interval even if it failed
+	maxFailures     int
+	computeErr      error
+	lastComputed    time.Time
+	enabled         *prometheus.Desc
+	toTolerate      *prometheus.Desc
+	planExistsFor   *prometheus.Desc
+	overcommitted   *prometheus.Desc
+	allowOvercommit *prometheus.Desc
+	maxToTolerate   *prometheus.Desc
+}
+
+func NewHACollector(xen *SammXen) Collector {
+	labels := []string{"pool_name", "pool_uuid"}
+	return &HACollector{
+		xen: xen,
+		enabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "ha_enabled"),
+			"Whether HA is enabled on the pool.",
+			labels, nil,
+		),
+		toTolerate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "ha_host_failures_to_tolerate"),
+			"Number of host failures the HA plan is configured to tolerate.",
+			labels, nil,
+		),
+		planExistsFor: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "ha_plan_exists_for"),
+			"Number of host failures the current HA plan can tolerate.",
+			labels, nil,
+		),
+		overcommitted: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "ha_overcommitted"),
+			"Whether the pool is overcommitted: not enough resources to tolerate the configured host failures.",
+			labels, nil,
+		),
+		allowOvercommit: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "ha_allow_overcommit"),
+			"Whether operations that overcommit the pool are allowed.",
+			labels, nil,
+		),
+		maxToTolerate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "ha_max_host_failures_to_tolerate"),
+			"Maximum number of host failures the pool could tolerate, as last computed by pool.ha_compute_max_host_failures_to_tolerate.",
+			labels, nil,
+		),
+	}
+}
+
+func (self *HACollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	pool, err := xen.Pool()
+	if err != nil {
+		return err
+	}
+	ch <- prometheus.MustNewConstMetric(self.enabled, prometheus.GaugeValue, boolToFloat(pool.HaEnabled), pool.NameLabel, pool.UUID)
+	ch <- prometheus.MustNewConstMetric(self.toTolerate, prometheus.GaugeValue, float64(pool.HaHostFailuresToTolerate), pool.NameLabel, pool.UUID)
+	ch <- prometheus.MustNewConstMetric(self.planExistsFor, prometheus.GaugeValue, float64(pool.HaPlanExistsFor), pool.NameLabel, pool.UUID)
+	ch <- prometheus.MustNewConstMetric(self.overcommitted, prometheus.GaugeValue, boolToFloat(pool.HaOvercommitted), pool.NameLabel, pool.UUID)
+	ch <- prometheus.MustNewConstMetric(self.allowOvercommit, prometheus.GaugeValue, boolToFloat(pool.HaAllowOvercommit), pool.NameLabel, pool.UUID)
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if time.Since(self.lastComputed) >= *haComputeInterval {
+		maxFailures, err := xenapi.Pool.HaComputeMaxHostFailuresToTolerate(xen.Session)
+		self.lastComputed = time.Now()
+		if err != nil {
+			self.computeErr = fmt.Errorf("pool.ha_compute_max_host_failures_to_tolerate: %w", err)
+		} else {
+			self.maxFailures, self.computeErr = maxFailures, nil
+		}
+	}
+	if self.computeErr != nil {
+		return self.computeErr
+	}
+	ch <- prometheus.MustNewConstMetric(self.maxToTolerate, prometheus.GaugeValue, float64(self.maxFailures), pool.NameLabel, pool.UUID)
+	return nil
+}
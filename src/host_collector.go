@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("host", NewHostCollector)
+}
+
+// HostCollector reports memory, liveness and CPU utilisation of every host
+// from the host_metrics and host_cpu objects.
+type HostCollector struct {
+	xen         *SammXen
+	info        *prometheus.Desc
+	memoryTotal *prometheus.Desc
+	memoryFree  *prometheus.Desc
+	live        *prometheus.Desc
+	lastUpdated *prometheus.Desc
+	cpu         *prometheus.Desc
+}
+
+func NewHostCollector(xen *SammXen) Collector {
+	labels := []string{"host_uuid", "hostname"}
+	return &HostCollector{
+		xen: xen,
+		info: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "info"),
+			"Software and hardware information of the host.",
+			append(labels, "version", "build", "edition", "cpu_model", "sched_policy"), nil,
+		),
+		memoryTotal: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "memory_total_bytes"),
+			"Total memory of the host.",
+			labels, nil,
+		),
+		memoryFree: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "memory_free_bytes"),
+			"Free memory of the host.",
+			labels, nil,
+		),
+		live: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "live"),
+			"Whether the pool master thinks the host is live.",
+			labels, nil,
+		),
+		lastUpdated: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "metrics_last_updated_timestamp_seconds"),
+			"Time at which the host metrics were last updated.",
+			labels, nil,
+		),
+		cpu: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "cpu_utilisation_ratio"),
+			"Utilisation of each physical CPU of the host, between 0 and 1.",
+			append(labels, "cpu"), nil,
+		),
+	}
+}
+
+func (self *HostCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+	metrics, err := xen.HostMetrics()
+	if err != nil {
+		return err
+	}
+	cpus, err := xen.HostCPUs()
+	if err != nil {
+		return err
+	}
+
+	for _, host := range hosts {
+		version := host.SoftwareVersion["product_version"]
+		if version == "" {
+			version = host.SoftwareVersion["platform_version"]
+		}
+		ch <- prometheus.MustNewConstMetric(self.info, prometheus.GaugeValue, 1,
+			host.UUID, host.Hostname, version, host.SoftwareVersion["build_number"],
+			host.Edition, host.CPUInfo["modelname"], host.SchedPolicy)
+
+		m, ok := metrics[host.Metrics]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(self.memoryTotal, prometheus.GaugeValue, float64(m.MemoryTotal), host.UUID, host.Hostname)
+		ch <- prometheus.MustNewConstMetric(self.memoryFree, prometheus.GaugeValue, float64(m.MemoryFree), host.UUID, host.Hostname)
+		ch <- prometheus.MustNewConstMetric(self.live, prometheus.GaugeValue, boolToFloat(m.Live), host.UUID, host.Hostname)
+		ch <- prometheus.MustNewConstMetric(self.lastUpdated, prometheus.GaugeValue, float64(m.LastUpdated.Unix()), host.UUID, host.Hostname)
+	}
+
+	for _, cpu := range cpus {
+		host, ok := hosts[cpu.Host]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(self.cpu, prometheus.GaugeValue, cpu.Utilisation, host.UUID, host.Hostname, strconv.Itoa(cpu.Number))
+	}
+	return nil
+}
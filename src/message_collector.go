@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+var messageLookback = flag.Duration("collector.message.lookback", 24*time.Hour, "How far back to count the XAPI messages raised before the exporter started.")
+
+func init() {
+	registerCollector("message", NewMessageCollector)
+}
+
+type messageKey struct {
+	name     string
+	priority int
+	cls      xenapi.Cls
+}
+
+type messageObject struct {
+	cls     xenapi.Cls
+	objUUID string
+	name    string
+}
+
+// MessageCollector follows the XAPI alerts with message.get_since. New
+// messages are counted by name, priority and class, and the messages still
+// present on the pool, listed with message.get_all, are reported per object.
+type MessageCollector struct {
+	xen *SammXen
+	mu  sync.Mutex
+	// Timestamp of the newest message seen so far
+	since time.Time
+	// Messages already counted that message.get_since may return again
+	counted map[xenapi.MessageRef]time.Time
+	// Object of the messages present on the pool, nil until first read
+	present  map[xenapi.MessageRef]messageObject
+	counts   map[messageKey]float64
+	total    *prometheus.Desc
+	messages *prometheus.Desc
+}
+
+func NewMessageCollector(xen *SammXen) Collector {
+	return &MessageCollector{
+		xen:     xen,
+		since:   time.Now().Add(-*messageLookback).UTC(),
+		counted: make(map[xenapi.MessageRef]time.Time),
+		counts:  make(map[messageKey]float64),
+		total: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "messages_total"),
+			"Number of XAPI messages raised since the exporter started, including those raised within the lookback before.",
+			[]string{"name", "priority", "cls"}, nil,
+		),
+		messages: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "messages"),
+			"Number of XAPI messages currently present for an object.",
+			[]string{"cls", "obj_uuid", "name"}, nil,
+		),
+	}
+}
+
+func (self *MessageCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	// message.get_since only returns the messages strictly newer than since,
+	// to the second, so the messages raised later within the same second as
+	// the newest one are looked for again.
+	added, err := xenapi.Message.GetSince(xen.Session, self.since.Add(-time.Second))
+	if err != nil {
+		return fmt.Errorf("message.get_since: %w", err)
+	}
+	for ref, message := range added {
+		if _, ok := self.counted[ref]; ok {
+			continue
+		}
+		self.counted[ref] = message.Timestamp
+		self.counts[messageKey{message.Name, message.Priority, message.Cls}]++
+		if message.Timestamp.After(self.since) {
+			self.since = message.Timestamp
+		}
+	}
+	for ref, timestamp := range self.counted {
+		if timestamp.Before(self.since.Add(-time.Second)) {
+			delete(self.counted, ref)
+		}
+	}
+
+	if err := self.refreshPresent(xen, added); err != nil {
+		return err
+	}
+
+	for key, count := range self.counts {
+		ch <- prometheus.MustNewConstMetric(self.total, prometheus.CounterValue, count, key.name, strconv.Itoa(key.priority), string(key.cls))
+	}
+	perObject := make(map[messageObject]float64)
+	for _, object := range self.present {
+		perObject[object]++
+	}
+	for key, count := range perObject {
+		ch <- prometheus.MustNewConstMetric(self.messages, prometheus.GaugeValue, count, string(key.cls), key.objUUID, key.name)
+	}
+	return nil
+}
+
+// refreshPresent updates the messages present on the pool. They are all read
+// once, then only listed: the records of new messages come from
+// message.get_since, those raised since it was called are read one by one.
+func (self *MessageCollector) refreshPresent(xen *SammXen, added map[xenapi.MessageRef]xenapi.MessageRecord) error {
+	if self.present == nil {
+		records, err := xenapi.Message.GetAllRecords(xen.Session)
+		if err != nil {
+			return fmt.Errorf("message.get_all_records: %w", err)
+		}
+		self.present = make(map[xenapi.MessageRef]messageObject, len(records))
+		for ref, message := range records {
+			self.present[ref] = messageObject{message.Cls, message.ObjUUID, message.Name}
+		}
+		return nil
+	}
+
+	refs, err := xenapi.Message.GetAll(xen.Session)
+	if err != nil {
+		return fmt.Errorf("message.get_all: %w", err)
+	}
+	for ref, message := range added {
+		self.present[ref] = messageObject{message.Cls, message.ObjUUID, message.Name}
+	}
+	present := make(map[xenapi.MessageRef]messageObject, len(refs))
+	for _, ref := range refs {
+		object, ok := self.present[ref]
+		if !ok {
+			message, err := xenapi.Message.GetRecord(xen.Session, ref)
+			if errors.Is(err, xenapi.ErrHandleInvalid) {
+				// Destroyed in the meantime
+				continue
+			} else if err != nil {
+				return fmt.Errorf("message.get_record: %w", err)
+			}
+			object = messageObject{message.Cls, message.ObjUUID, message.Name}
+		}
+		present[ref] = object
+	}
+	self.present = present
+	return nil
+}
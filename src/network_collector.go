@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("network", NewNetworkCollector)
+}
+
+// NetworkCollector reports link state and throughput of the physical
+// interfaces of every host, and the number of links up in every bond.
+type NetworkCollector struct {
+	xen         *SammXen
+	pifInfo     *prometheus.Desc
+	pifCarrier  *prometheus.Desc
+	pifSpeed    *prometheus.Desc
+	pifDuplex   *prometheus.Desc
+	pifIo       *prometheus.Desc
+	bondLinksUp *prometheus.Desc
+	bondSlaves  *prometheus.Desc
+}
+
+func NewNetworkCollector(xen *SammXen) Collector {
+	labels := []string{"host", "device", "pif_uuid"}
+	bondLabels := []string{"host", "device", "bond_uuid", "mode"}
+	return &NetworkCollector{
+		xen: xen,
+		pifInfo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pif", "info"),
+			"Hardware information of the physical interface.",
+			append(labels, "mac", "vendor_id", "vendor_name", "device_id", "device_name", "pci_bus_path"), nil,
+		),
+		pifCarrier: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pif", "carrier"),
+			"Whether the interface has a carrier.",
+			labels, nil,
+		),
+		pifSpeed: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pif", "speed_bytes_per_second"),
+			"Negotiated speed of the link.",
+			labels, nil,
+		),
+		pifDuplex: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pif", "duplex"),
+			"Whether the link is full duplex.",
+			labels, nil,
+		),
+		pifIo: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pif", "io_bytes_per_second"),
+			"Read or write throughput of the interface.",
+			append(labels, "mode"), nil,
+		),
+		bondLinksUp: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bond", "links_up"),
+			"Number of links up in the bond.",
+			bondLabels, nil,
+		),
+		bondSlaves: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "bond", "slaves"),
+			"Number of interfaces that are part of the bond.",
+			bondLabels, nil,
+		),
+	}
+}
+
+func (self *NetworkCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+	pifs, err := xen.PIFs()
+	if err != nil {
+		return err
+	}
+	metrics, err := xen.PIFMetrics()
+	if err != nil {
+		return err
+	}
+	bonds, err := xenapi.Bond.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("Bond.get_all_records: %w", err)
+	}
+
+	for _, pif := range pifs {
+		if !pif.Physical {
+			continue
+		}
+		m, ok := metrics[pif.Metrics]
+		if !ok {
+			continue
+		}
+		labels := []string{hosts[pif.Host].NameLabel, pif.Device, pif.UUID}
+		ch <- prometheus.MustNewConstMetric(self.pifInfo, prometheus.GaugeValue, 1,
+			append(labels, pif.MAC, m.VendorID, m.VendorName, m.DeviceID, m.DeviceName, m.PciBusPath)...)
+		ch <- prometheus.MustNewConstMetric(self.pifCarrier, prometheus.GaugeValue, boolToFloat(m.Carrier), labels...)
+		ch <- prometheus.MustNewConstMetric(self.pifSpeed, prometheus.GaugeValue, float64(m.Speed)*1e6/8, labels...)
+		ch <- prometheus.MustNewConstMetric(self.pifDuplex, prometheus.GaugeValue, boolToFloat(m.Duplex), labels...)
+		ch <- prometheus.MustNewConstMetric(self.pifIo, prometheus.GaugeValue, m.IoReadKbs*1024, append(labels, "read")...)
+		ch <- prometheus.MustNewConstMetric(self.pifIo, prometheus.GaugeValue, m.IoWriteKbs*1024, append(labels, "write")...)
+	}
+
+	for _, bond := range bonds {
+		master := pifs[bond.Master]
+		labels := []string{hosts[master.Host].NameLabel, master.Device, bond.UUID, string(bond.Mode)}
+		ch <- prometheus.MustNewConstMetric(self.bondLinksUp, prometheus.GaugeValue, float64(bond.LinksUp), labels...)
+		ch <- prometheus.MustNewConstMetric(self.bondSlaves, prometheus.GaugeValue, float64(len(bond.Slaves)), labels...)
+	}
+	return nil
+}
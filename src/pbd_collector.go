@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("pbd", NewPBDCollector)
+}
+
+// XAPI writes the path counts of multipathed devices in the PBD other_config
+// as "mpath-<SCSI id>": "[active, total]".
+var multipathCountRegexp = regexp.MustCompile(`^\[\s*(\d+)\s*,\s*(\d+)\s*\]$`)
+
+// PBDCollector reports whether every SR is plugged on every host and how many
+// paths of its multipathed devices are active.
+type PBDCollector struct {
+	xen         *SammXen
+	attached    *prometheus.Desc
+	activePaths *prometheus.Desc
+	totalPaths  *prometheus.Desc
+}
+
+func NewPBDCollector(xen *SammXen) Collector {
+	labels := []string{"host_uuid", "host", "sr_uuid", "sr", "shared"}
+	return &PBDCollector{
+		xen: xen,
+		attached: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pbd", "currently_attached"),
+			"Whether the SR is plugged on the host.",
+			labels, nil,
+		),
+		activePaths: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pbd", "multipath_active_paths"),
+			"Number of active paths to a multipathed device of the SR.",
+			append(labels, "scsi_id"), nil,
+		),
+		totalPaths: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pbd", "multipath_paths"),
+			"Number of known paths to a multipathed device of the SR.",
+			append(labels, "scsi_id"), nil,
+		),
+	}
+}
+
+func (self *PBDCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	pbds, err := xenapi.PBD.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("PBD.get_all_records: %w", err)
+	}
+	srs, err := xen.SRs()
+	if err != nil {
+		return err
+	}
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+
+	for _, pbd := range pbds {
+		sr := srs[pbd.SR]
+		host := hosts[pbd.Host]
+		labels := []string{host.UUID, host.NameLabel, sr.UUID, sr.NameLabel, strconv.FormatBool(sr.Shared)}
+		ch <- prometheus.MustNewConstMetric(self.attached, prometheus.GaugeValue, boolToFloat(pbd.CurrentlyAttached), labels...)
+		for key, value := range pbd.OtherConfig {
+			var scsiID string
+			switch {
+			case strings.HasPrefix(key, "mpath-"):
+				scsiID = strings.TrimPrefix(key, "mpath-")
+			case strings.HasPrefix(key, "multipath-"):
+				scsiID = strings.TrimPrefix(key, "multipath-")
+			default:
+				continue
+			}
+			m := multipathCountRegexp.FindStringSubmatch(value)
+			if m == nil {
+				continue
+			}
+			active, _ := strconv.Atoi(m[1])
+			total, _ := strconv.Atoi(m[2])
+			ch <- prometheus.MustNewConstMetric(self.activePaths, prometheus.GaugeValue, float64(active), append(labels, scsiID)...)
+			ch <- prometheus.MustNewConstMetric(self.totalPaths, prometheus.GaugeValue, float64(total), append(labels, scsiID)...)
+		}
+	}
+	return nil
+}
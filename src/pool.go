@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"xenapi"
+)
+
+// poolOptions are the options of the command line applying to every pool.
+type poolOptions struct {
+	useCache  bool
+	keepAlive time.Duration
+}
+
+// start runs the object cache and the keepalive of xen in the background
+// until ctx is done.
+func (self poolOptions) start(ctx context.Context, xen *SammXen, background *sync.WaitGroup) {
+	if self.useCache {
+		xen.Cache = NewObjectCache(xen)
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			xen.Cache.Run(ctx)
+		}()
+	}
+	if self.keepAlive > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			xen.KeepAlive(ctx, self.keepAlive)
+		}()
+	}
+}
+
+// Pool is a pool of the configuration file. It is collected in the background
+// every interval and implements prometheus.Gatherer with the result of the
+// last collection, so that a slow or dead pool does not hold up the others.
+type Pool struct {
+	config      PoolConfig
+	credentials CredentialsConfig
+	opts        poolOptions
+
+	// Set once logged in
+	xen       *SammXen
+	collector *XenCollector
+
+	// Static labels already logged as clashing, by label and metric name
+	clashes map[string]bool
+
+	mu       sync.RWMutex
+	families []*dto.MetricFamily
+}
+
+func NewPool(config PoolConfig, credentials CredentialsConfig, opts poolOptions) *Pool {
+	return &Pool{config: config, credentials: credentials, opts: opts, clashes: make(map[string]bool)}
+}
+
+// Run collects the pool until ctx is done, then logs out.
+func (self *Pool) Run(ctx context.Context) {
+	var background sync.WaitGroup
+	ticker := time.NewTicker(self.config.Interval)
+	defer ticker.Stop()
+	for {
+		self.collect(ctx, &background)
+		select {
+		case <-ctx.Done():
+			background.Wait()
+			if self.xen != nil {
+				if err := self.xen.Logout(); err != nil {
+					log.Printf("pool %s: logout: %s", self.config.Name, err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// connect logs in to the pool unless already logged in. The login is aborted
+// when loginCtx is done, the background goroutines run until ctx is.
+func (self *Pool) connect(ctx context.Context, loginCtx context.Context, background *sync.WaitGroup) error {
+	if self.xen != nil {
+		return nil
+	}
+	password, err := self.credentials.password()
+	if err != nil {
+		return fmt.Errorf("credentials %s: %w", self.config.Credentials, err)
+	}
+	xen, err := newSammXen(loginCtx, self.config.addresses(), self.config.secureOpts(), self.credentials.Username, password, self.config.CAFile != "")
+	if err != nil {
+		return err
+	}
+	collector, err := NewXenCollector(xen, self.config.Collectors)
+	if err != nil {
+		return err
+	}
+	log.Printf("pool %s: logged in to %s, session %s", self.config.Name, xen.Session.URL(), xen.SessionId())
+
+	self.opts.start(ctx, xen, background)
+	self.xen, self.collector = xen, collector
+	return nil
+}
+
+func (self *Pool) collect(ctx context.Context, background *sync.WaitGroup) {
+	// Give up on the collection when the next one is due
+	collectCtx, cancel := context.WithTimeout(ctx, self.config.Interval)
+	defer cancel()
+
+	reg := prometheus.NewRegistry()
+	up := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "up",
+		Help:      "Whether the pool answered XenAPI calls during the last collection.",
+	})
+	last := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_collection_timestamp_seconds",
+		Help:      "Time the metrics of the pool were collected.",
+	})
+	reg.MustRegister(up, last)
+	last.SetToCurrentTime()
+
+	if err := self.connect(ctx, collectCtx, background); err != nil {
+		log.Printf("pool %s: %s", self.config.Name, err)
+	} else if _, err := xenapi.Pool.GetAll(self.xen.Session.WithContext(collectCtx)); err != nil {
+		// Spare the collectors from all failing the same way
+		log.Printf("pool %s: %s", self.config.Name, err)
+	} else {
+		up.Set(1)
+		reg.MustRegister(self.collector.WithContext(collectCtx))
+	}
+
+	families, err := reg.Gather()
+	if err != nil {
+		log.Printf("pool %s: %s", self.config.Name, err)
+	}
+	self.addLabels(families)
+	self.mu.Lock()
+	self.families = families
+	self.mu.Unlock()
+}
+
+// addLabels adds the pool label and the static labels of the pool to every
+// metric. A metric that already has a label of the same name keeps its own,
+// so that a static label clashing with those of a collector does not fail
+// the whole scrape with a duplicate label.
+func (self *Pool) addLabels(families []*dto.MetricFamily) {
+	labels := map[string]string{"pool": self.config.Name}
+	for name, value := range self.config.Labels {
+		labels[name] = value
+	}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			names := make(map[string]bool, len(metric.Label))
+			for _, pair := range metric.Label {
+				names[pair.GetName()] = true
+			}
+			for name, value := range labels {
+				if names[name] {
+					self.warnClash(name, family.GetName())
+					continue
+				}
+				name, value := name, value
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+			}
+			sort.Slice(metric.Label, func(i, j int) bool {
+				return metric.Label[i].GetName() < metric.Label[j].GetName()
+			})
+		}
+	}
+}
+
+// warnClash logs once per label and metric that a static label was not added.
+func (self *Pool) warnClash(label string, metric string) {
+	key := label + "/" + metric
+	if self.clashes[key] {
+		return
+	}
+	self.clashes[key] = true
+	log.Printf("pool %s: label %s is not added to %s, which has its own", self.config.Name, label, metric)
+}
+
+func (self *Pool) Gather() ([]*dto.MetricFamily, error) {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.families, nil
+}
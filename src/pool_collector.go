@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("pool", NewPoolCollector)
+}
+
+// PoolCollector reports an inventory of the objects in the pool.
+type PoolCollector struct {
+	xen       *SammXen
+	hosts     *prometheus.Desc
+	vms       *prometheus.Desc
+	templates *prometheus.Desc
+	srs       *prometheus.Desc
+	networks  *prometheus.Desc
+}
+
+func NewPoolCollector(xen *SammXen) Collector {
+	labels := []string{"pool_name", "pool_uuid"}
+	return &PoolCollector{
+		xen: xen,
+		hosts: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "hosts"),
+			"Number of hosts in the pool.",
+			labels, nil,
+		),
+		vms: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "vms"),
+			"Number of VMs in the pool by power state, excluding templates, snapshots and control domains.",
+			append(labels, "power_state"), nil,
+		),
+		templates: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "templates"),
+			"Number of VM templates in the pool.",
+			labels, nil,
+		),
+		srs: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "srs"),
+			"Number of storage repositories in the pool.",
+			labels, nil,
+		),
+		networks: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "networks"),
+			"Number of networks in the pool.",
+			labels, nil,
+		),
+	}
+}
+
+func (self *PoolCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	pool, err := xen.Pool()
+	if err != nil {
+		return err
+	}
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+	vms, err := xen.VMs()
+	if err != nil {
+		return err
+	}
+	srs, err := xen.SRs()
+	if err != nil {
+		return err
+	}
+	networks, err := xen.Networks()
+	if err != nil {
+		return err
+	}
+
+	powerStates := map[xenapi.VMPowerState]int{
+		xenapi.VMPowerStateHalted:    0,
+		xenapi.VMPowerStatePaused:    0,
+		xenapi.VMPowerStateRunning:   0,
+		xenapi.VMPowerStateSuspended: 0,
+	}
+	templates := 0
+	for _, vm := range vms {
+		switch {
+		case vm.IsASnapshot, vm.IsControlDomain:
+		case vm.IsATemplate:
+			templates++
+		default:
+			powerStates[vm.PowerState]++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(self.hosts, prometheus.GaugeValue, float64(len(hosts)), pool.NameLabel, pool.UUID)
+	for state, count := range powerStates {
+		ch <- prometheus.MustNewConstMetric(self.vms, prometheus.GaugeValue, float64(count), pool.NameLabel, pool.UUID, string(state))
+	}
+	ch <- prometheus.MustNewConstMetric(self.templates, prometheus.GaugeValue, float64(templates), pool.NameLabel, pool.UUID)
+	ch <- prometheus.MustNewConstMetric(self.srs, prometheus.GaugeValue, float64(len(srs)), pool.NameLabel, pool.UUID)
+	ch <- prometheus.MustNewConstMetric(self.networks, prometheus.GaugeValue, float64(len(networks)), pool.NameLabel, pool.UUID)
+	return nil
+}
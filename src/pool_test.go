@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func labelPairs(labels map[string]string) []*dto.LabelPair {
+	var pairs []*dto.LabelPair
+	for name, value := range labels {
+		name, value := name, value
+		pairs = append(pairs, &dto.LabelPair{Name: &name, Value: &value})
+	}
+	return pairs
+}
+
+func TestPoolAddLabels(t *testing.T) {
+	pool := NewPool(PoolConfig{Name: "prod-a", Labels: map[string]string{"datacenter": "par1", "host": "static"}}, CredentialsConfig{}, poolOptions{})
+	hostLive, hostMemory := "xen_host_live", "xen_host_memory_free_bytes"
+	gather := func() []*dto.MetricFamily {
+		return []*dto.MetricFamily{
+			{Name: &hostLive, Metric: []*dto.Metric{
+				{Label: labelPairs(map[string]string{"host_uuid": "1", "host": "xcp1"})},
+				{Label: labelPairs(map[string]string{"host_uuid": "2", "host": "xcp2"})},
+			}},
+			{Name: &hostMemory, Metric: []*dto.Metric{
+				{Label: labelPairs(map[string]string{"host_uuid": "1"})},
+			}},
+		}
+	}
+
+	// Clashes are only logged once over the collections
+	var families []*dto.MetricFamily
+	for i := 0; i < 2; i++ {
+		families = gather()
+		pool.addLabels(families)
+	}
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			labels := make(map[string]string)
+			previous := ""
+			for _, pair := range metric.Label {
+				if _, ok := labels[pair.GetName()]; ok {
+					t.Fatalf("%s: duplicate label %s", family.GetName(), pair.GetName())
+				}
+				if pair.GetName() < previous {
+					t.Fatalf("%s: labels are not sorted: %v", family.GetName(), metric.Label)
+				}
+				previous = pair.GetName()
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["pool"] != "prod-a" || labels["datacenter"] != "par1" {
+				t.Fatalf("%s: expected the pool and static labels, got %v", family.GetName(), labels)
+			}
+			// The static host label only fills in for metrics without one
+			if family.GetName() == hostLive && labels["host"] == "static" {
+				t.Fatalf("%s: expected the label of the collector to win, got %v", family.GetName(), labels)
+			}
+			if family.GetName() == hostMemory && labels["host"] != "static" {
+				t.Fatalf("%s: expected the static label, got %v", family.GetName(), labels)
+			}
+		}
+	}
+	if len(pool.clashes) != 1 || !pool.clashes["host/"+hostLive] {
+		t.Fatalf("expected a single clash on %s, got %v", hostLive, pool.clashes)
+	}
+}
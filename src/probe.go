@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The module used by /probe when the request does not name one
+const defaultProbeModule = "default"
+
+// probeKey identifies the sessions of the prober. Modules with the same
+// credentials and certificates share the session of a target.
+type probeKey struct {
+	target      string
+	credentials string
+	tls         TLSFiles
+}
+
+// probeTarget is the session of a target along with the collectors of each
+// module, which are kept from one probe to the next since several of them
+// report what happened since the previous scrape.
+type probeTarget struct {
+	name string
+
+	mu         sync.Mutex
+	xen        *SammXen
+	collectors map[string]*XenCollector
+	// Closed once the login in progress, if any, is over
+	loggingIn chan struct{}
+	lastProbe time.Time
+
+	// Stops the cache and keepalive of the session
+	cancel     context.CancelFunc
+	background sync.WaitGroup
+}
+
+// Prober serves /probe?target=<pool>&module=<name>, collecting the target with
+// the collectors and credentials of the module at scrape time, the way the
+// blackbox and SNMP exporters do. The target is the name of a pool of the
+// configuration file or the address of a host, which the module may restrict.
+// Targets that are not probed for idleTimeout, unless zero, are logged out.
+type Prober struct {
+	config        *Config
+	opts          poolOptions
+	timeoutOffset time.Duration
+	idleTimeout   time.Duration
+
+	// The sessions of the targets run until ctx is done
+	ctx        context.Context
+	background sync.WaitGroup
+
+	mu      sync.Mutex
+	targets map[probeKey]*probeTarget
+}
+
+func NewProber(ctx context.Context, config *Config, opts poolOptions, timeoutOffset time.Duration, idleTimeout time.Duration) *Prober {
+	prober := &Prober{
+		config:        config,
+		opts:          opts,
+		timeoutOffset: timeoutOffset,
+		idleTimeout:   idleTimeout,
+		ctx:           ctx,
+		targets:       make(map[probeKey]*probeTarget),
+	}
+	if idleTimeout > 0 {
+		prober.background.Add(1)
+		go func() {
+			defer prober.background.Done()
+			prober.evictIdle()
+		}()
+	}
+	return prober
+}
+
+func (self *Prober) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	target := query.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	moduleName := query.Get("module")
+	if moduleName == "" {
+		moduleName = defaultProbeModule
+	}
+	module, ok := self.config.Modules[moduleName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+	addresses, ok := self.addresses(target, module)
+	if !ok {
+		http.Error(w, fmt.Sprintf("target %q is neither a configured pool nor allowed by the targets of module %q", target, moduleName), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := scrapeContext(r, self.timeoutOffset)
+	defer cancel()
+	reg := prometheus.NewRegistry()
+	up := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "up",
+		Help:      "Whether the target could be logged in to.",
+	})
+	reg.MustRegister(up)
+	collector, err := self.collector(ctx, target, addresses, moduleName, module)
+	if err != nil {
+		log.Printf("probe %s: %s", target, err)
+	} else {
+		up.Set(1)
+		reg.MustRegister(collector.WithContext(ctx))
+	}
+	promhttp.HandlerFor(reg, promhttp.HandlerOpts{EnableOpenMetrics: true}).ServeHTTP(w, r)
+}
+
+// addresses returns the addresses to log in to for target, if the module may
+// probe it.
+func (self *Prober) addresses(target string, module ModuleConfig) ([]string, bool) {
+	for _, pool := range self.config.Pools {
+		if pool.Name == target {
+			return pool.addresses(), true
+		}
+	}
+	if module.allows(target) {
+		return []string{target}, true
+	}
+	return nil, false
+}
+
+// collector returns the collector of the module for target, logging in to the
+// target first if no session is open yet. Concurrent probes of a target wait
+// for a single login, or until ctx is done.
+func (self *Prober) collector(ctx context.Context, target string, addresses []string, moduleName string, module ModuleConfig) (*XenCollector, error) {
+	key := probeKey{target: target, credentials: module.Credentials, tls: module.TLSFiles}
+	self.mu.Lock()
+	t, ok := self.targets[key]
+	if !ok {
+		t = &probeTarget{name: target, collectors: make(map[string]*XenCollector)}
+		self.targets[key] = t
+	}
+	// Under the lock of the prober, so that the target is not evicted
+	t.mu.Lock()
+	t.lastProbe = time.Now()
+	t.mu.Unlock()
+	self.mu.Unlock()
+
+	t.mu.Lock()
+	for t.xen == nil {
+		if t.loggingIn == nil {
+			t.loggingIn = make(chan struct{})
+			t.mu.Unlock()
+			err := self.login(ctx, t, addresses, module)
+			t.mu.Lock()
+			close(t.loggingIn)
+			t.loggingIn = nil
+			if err != nil {
+				t.mu.Unlock()
+				return nil, err
+			}
+			break
+		}
+		loggingIn := t.loggingIn
+		t.mu.Unlock()
+		select {
+		case <-loggingIn:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		t.mu.Lock()
+	}
+	defer t.mu.Unlock()
+	collector, ok := t.collectors[moduleName]
+	if !ok {
+		var err error
+		collector, err = NewXenCollector(t.xen, module.Collectors)
+		if err != nil {
+			return nil, err
+		}
+		t.collectors[moduleName] = collector
+	}
+	return collector, nil
+}
+
+// login opens the session of t, giving up once ctx is done.
+func (self *Prober) login(ctx context.Context, t *probeTarget, addresses []string, module ModuleConfig) error {
+	credentials := self.config.Credentials[module.Credentials]
+	password, err := credentials.password()
+	if err != nil {
+		return fmt.Errorf("credentials %s: %w", module.Credentials, err)
+	}
+	xen, err := newSammXen(ctx, addresses, module.secureOpts(), credentials.Username, password, module.CAFile != "")
+	if err != nil {
+		return err
+	}
+	log.Printf("probe %s: logged in to %s, session %s", t.name, xen.Session.URL(), xen.SessionId())
+
+	targetCtx, cancel := context.WithCancel(self.ctx)
+	self.opts.start(targetCtx, xen, &t.background)
+	t.mu.Lock()
+	t.xen, t.cancel = xen, cancel
+	t.mu.Unlock()
+	return nil
+}
+
+// evictIdle logs out of the targets that were not probed for idleTimeout,
+// until the context of the prober is done.
+func (self *Prober) evictIdle() {
+	ticker := time.NewTicker(self.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var idle []*probeTarget
+		self.mu.Lock()
+		for key, t := range self.targets {
+			t.mu.Lock()
+			if t.loggingIn == nil && time.Since(t.lastProbe) > self.idleTimeout {
+				delete(self.targets, key)
+				idle = append(idle, t)
+			}
+			t.mu.Unlock()
+		}
+		self.mu.Unlock()
+		for _, t := range idle {
+			t.close()
+		}
+	}
+}
+
+// close stops the cache and keepalive of the session, then logs it out.
+func (self *probeTarget) close() {
+	self.mu.Lock()
+	xen, cancel := self.xen, self.cancel
+	self.mu.Unlock()
+	if xen == nil {
+		return
+	}
+	cancel()
+	self.background.Wait()
+	if err := xen.Logout(); err != nil {
+		log.Printf("probe %s: logout: %s", self.name, err)
+	}
+}
+
+// Close logs out of every target once the context of the prober is done.
+func (self *Prober) Close() {
+	self.background.Wait()
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, t := range self.targets {
+		t.close()
+	}
+}
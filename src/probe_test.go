@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakePool is a XAPI host that counts the logins and logouts, and answers
+// the calls of the collectors with no objects.
+type fakePool struct {
+	mu      sync.Mutex
+	logins  int
+	logouts int
+	// Closed to let the logins answer, if set
+	release chan struct{}
+}
+
+func (self *fakePool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Method string `json:"method"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return
+	}
+	result := `{}`
+	switch request.Method {
+	case "session.login_with_password":
+		if self.release != nil {
+			<-self.release
+		}
+		self.mu.Lock()
+		self.logins++
+		self.mu.Unlock()
+		result = `"OpaqueRef:session"`
+	case "session.logout":
+		self.mu.Lock()
+		self.logouts++
+		self.mu.Unlock()
+		result = `""`
+	case "pool.get_all":
+		result = `["OpaqueRef:pool"]`
+	case "pool.get_record":
+		result = `{"master":"OpaqueRef:host"}`
+	case "host.get_record":
+		result = `{"API_version_major":2,"API_version_minor":21,"software_version":{"xapi":"24.0"}}`
+	case "session.get_record":
+		result = `{"uuid":"6c5a1a0e-0000-0000-0000-000000000001"}`
+	}
+	_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":` + result + `}`))
+}
+
+func (self *fakePool) counts() (int, int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.logins, self.logouts
+}
+
+func newTestProber(t *testing.T, idleTimeout time.Duration, targets ...string) *Prober {
+	t.Helper()
+	config := &Config{
+		Credentials: map[string]CredentialsConfig{"test": {Username: "root", Password: "secret"}},
+		Pools:       []PoolConfig{{Name: "prod-a", Address: "127.0.0.1:1", Credentials: "test"}},
+		Modules: map[string]ModuleConfig{
+			defaultProbeModule: {Credentials: "test", Collectors: []string{"pool"}, Targets: targets},
+		},
+	}
+	if err := config.check(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	prober := NewProber(ctx, config, poolOptions{}, 0, idleTimeout)
+	t.Cleanup(func() {
+		cancel()
+		prober.Close()
+	})
+	return prober
+}
+
+func probe(prober *Prober, query url.Values) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	prober.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/probe?"+query.Encode(), nil))
+	return recorder
+}
+
+func TestProbeBadRequest(t *testing.T) {
+	prober := newTestProber(t, 0, "10.0.1.1", "10.0.2.0/24")
+	tests := map[string]url.Values{
+		"no target":        {},
+		"unknown module":   {"target": {"10.0.1.1"}, "module": {"nope"}},
+		"not allowed":      {"target": {"10.0.3.1"}},
+		"not in the range": {"target": {"10.0.1.2:443"}},
+	}
+	for name, query := range tests {
+		t.Run(name, func(t *testing.T) {
+			if recorder := probe(prober, query); recorder.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestProbeSingleLogin(t *testing.T) {
+	fake := &fakePool{release: make(chan struct{})}
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+	target := server.Listener.Addr().String()
+	prober := newTestProber(t, 0)
+
+	var wg sync.WaitGroup
+	responses := make([]*httptest.ResponseRecorder, 4)
+	for i := range responses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i] = probe(prober, url.Values{"target": {target}})
+		}(i)
+	}
+	// Let the probes queue up behind the first login
+	time.Sleep(50 * time.Millisecond)
+	close(fake.release)
+	wg.Wait()
+
+	for _, recorder := range responses {
+		if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "xen_up 1") {
+			t.Fatalf("expected the target to be up, got %d: %s", recorder.Code, recorder.Body)
+		}
+	}
+	if logins, _ := fake.counts(); logins != 1 {
+		t.Fatalf("expected a single login for concurrent probes, got %d", logins)
+	}
+}
+
+func TestProbeIdleEviction(t *testing.T) {
+	fake := &fakePool{}
+	server := httptest.NewTLSServer(fake)
+	defer server.Close()
+	target := server.Listener.Addr().String()
+	prober := newTestProber(t, 50*time.Millisecond)
+
+	if recorder := probe(prober, url.Values{"target": {target}}); !strings.Contains(recorder.Body.String(), "xen_up 1") {
+		t.Fatalf("expected the target to be up, got %d: %s", recorder.Code, recorder.Body)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, logouts := fake.counts(); logouts == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the idle target to be logged out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Probing it again logs in again
+	if recorder := probe(prober, url.Values{"target": {target}}); !strings.Contains(recorder.Body.String(), "xen_up 1") {
+		t.Fatalf("expected the target to be up, got %d: %s", recorder.Code, recorder.Body)
+	}
+	if logins, _ := fake.counts(); logins != 2 {
+		t.Fatalf("expected a new login after the eviction, got %d logins", logins)
+	}
+}
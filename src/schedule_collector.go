@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("schedule", NewScheduleCollector)
+}
+
+// scheduleInterval returns the time expected between two runs of a snapshot
+// schedule or protection policy of the given frequency, or 0 if the schedule
+// does not run periodically.
+func scheduleInterval(frequency string) time.Duration {
+	switch frequency {
+	case "hourly":
+		return time.Hour
+	case "daily":
+		return 24 * time.Hour
+	case "weekly":
+		return 7 * 24 * time.Hour
+	}
+	return 0
+}
+
+// ScheduleCollector reports the VM snapshot schedules (VMSS) and, on pools
+// old enough to still have them, the VM protection policies (VMPP), so that
+// a schedule that silently stopped running can be alerted on.
+type ScheduleCollector struct {
+	xen *SammXen
+
+	vmssEnabled  *prometheus.Desc
+	vmssRetained *prometheus.Desc
+	vmssLastRun  *prometheus.Desc
+	vmssInterval *prometheus.Desc
+	vmssOverdue  *prometheus.Desc
+	vmssVM       *prometheus.Desc
+	vmppEnabled  *prometheus.Desc
+	vmppLastRun  *prometheus.Desc
+	vmppInterval *prometheus.Desc
+	vmppOverdue  *prometheus.Desc
+	vmppAlerts   *prometheus.Desc
+	vmppVM       *prometheus.Desc
+}
+
+func NewScheduleCollector(xen *SammXen) Collector {
+	vmssLabels := []string{"vmss_uuid", "vmss", "type", "frequency"}
+	vmppLabels := []string{"vmpp_uuid", "vmpp", "job", "frequency"}
+	return &ScheduleCollector{
+		xen: xen,
+		vmssEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmss", "enabled"),
+			"Whether the snapshot schedule is enabled.",
+			vmssLabels, nil,
+		),
+		vmssRetained: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmss", "retained_snapshots"),
+			"Maximum number of snapshots kept by the snapshot schedule.",
+			vmssLabels, nil,
+		),
+		vmssLastRun: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmss", "last_run_timestamp_seconds"),
+			"Time of the last snapshot taken by the snapshot schedule.",
+			vmssLabels, nil,
+		),
+		vmssInterval: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmss", "expected_interval_seconds"),
+			"Time expected between two runs of the snapshot schedule.",
+			vmssLabels, nil,
+		),
+		vmssOverdue: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmss", "overdue_seconds"),
+			"Time since the last run of an enabled snapshot schedule in excess of its expected interval, 0 if on time.",
+			vmssLabels, nil,
+		),
+		vmssVM: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmss", "vm_info"),
+			"VM attached to the snapshot schedule.",
+			[]string{"vmss_uuid", "vmss", "vm_uuid", "vm"}, nil,
+		),
+		vmppEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmpp", "enabled"),
+			"Whether the protection policy is enabled.",
+			[]string{"vmpp_uuid", "vmpp"}, nil,
+		),
+		vmppLastRun: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmpp", "last_run_timestamp_seconds"),
+			"Time of the last backup or archive run of the protection policy.",
+			vmppLabels, nil,
+		),
+		vmppInterval: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmpp", "expected_interval_seconds"),
+			"Time expected between two backup or archive runs of the protection policy.",
+			vmppLabels, nil,
+		),
+		vmppOverdue: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmpp", "overdue_seconds"),
+			"Time since the last backup or archive run of an enabled protection policy in excess of its expected interval, 0 if on time.",
+			vmppLabels, nil,
+		),
+		vmppAlerts: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmpp", "recent_alerts"),
+			"Number of recent alerts of the protection policy.",
+			[]string{"vmpp_uuid", "vmpp"}, nil,
+		),
+		vmppVM: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vmpp", "vm_info"),
+			"VM protected by the protection policy.",
+			[]string{"vmpp_uuid", "vmpp", "vm_uuid", "vm"}, nil,
+		),
+	}
+}
+
+func (self *ScheduleCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	vms, err := xen.VMs()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+
+	// VMSS was introduced in XenServer 7.2
+	if xen.Session.APIVersion >= xenapi.APIVersion2_7 {
+		if err := self.updateVMSS(xen, ch, vms, now); err != nil {
+			return err
+		}
+	}
+
+	// VMPP was removed in XenServer 7.0
+	if xen.Session.APIVersion < xenapi.APIVersion2_5 {
+		return self.updateVMPP(xen, ch, vms, now)
+	}
+	return nil
+}
+
+func (self *ScheduleCollector) updateVMSS(xen *SammXen, ch chan<- prometheus.Metric, vms map[xenapi.VMRef]xenapi.VMRecord, now time.Time) error {
+	schedules, err := xenapi.VMSS.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("VMSS.get_all_records: %w", err)
+	}
+	for _, vmss := range schedules {
+		labels := []string{vmss.UUID, vmss.NameLabel, string(vmss.Type), string(vmss.Frequency)}
+		ch <- prometheus.MustNewConstMetric(self.vmssEnabled, prometheus.GaugeValue, boolToFloat(vmss.Enabled), labels...)
+		ch <- prometheus.MustNewConstMetric(self.vmssRetained, prometheus.GaugeValue, float64(vmss.RetainedSnapshots), labels...)
+		ch <- prometheus.MustNewConstMetric(self.vmssLastRun, prometheus.GaugeValue, float64(vmss.LastRunTime.Unix()), labels...)
+		if interval := scheduleInterval(string(vmss.Frequency)); interval > 0 {
+			ch <- prometheus.MustNewConstMetric(self.vmssInterval, prometheus.GaugeValue, interval.Seconds(), labels...)
+			if vmss.Enabled {
+				ch <- prometheus.MustNewConstMetric(self.vmssOverdue, prometheus.GaugeValue, overdue(now, vmss.LastRunTime, interval), labels...)
+			}
+		}
+		for _, ref := range vmss.VMs {
+			vm := vms[ref]
+			ch <- prometheus.MustNewConstMetric(self.vmssVM, prometheus.GaugeValue, 1, vmss.UUID, vmss.NameLabel, vm.UUID, vm.NameLabel)
+		}
+	}
+	return nil
+}
+
+func (self *ScheduleCollector) updateVMPP(xen *SammXen, ch chan<- prometheus.Metric, vms map[xenapi.VMRef]xenapi.VMRecord, now time.Time) error {
+	policies, err := xenapi.VMPP.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("VMPP.get_all_records: %w", err)
+	}
+	for _, vmpp := range policies {
+		ch <- prometheus.MustNewConstMetric(self.vmppEnabled, prometheus.GaugeValue, boolToFloat(vmpp.IsPolicyEnabled), vmpp.UUID, vmpp.NameLabel)
+		ch <- prometheus.MustNewConstMetric(self.vmppAlerts, prometheus.GaugeValue, float64(len(vmpp.RecentAlerts)), vmpp.UUID, vmpp.NameLabel)
+
+		backupInterval := scheduleInterval(string(vmpp.BackupFrequency))
+		self.vmppJob(ch, vmpp, "backup", string(vmpp.BackupFrequency), vmpp.BackupLastRunTime, backupInterval, now)
+		if vmpp.ArchiveFrequency != xenapi.VmppArchiveFrequencyNever {
+			archiveInterval := scheduleInterval(string(vmpp.ArchiveFrequency))
+			if vmpp.ArchiveFrequency == xenapi.VmppArchiveFrequencyAlwaysAfterBackup {
+				archiveInterval = backupInterval
+			}
+			self.vmppJob(ch, vmpp, "archive", string(vmpp.ArchiveFrequency), vmpp.ArchiveLastRunTime, archiveInterval, now)
+		}
+		for _, ref := range vmpp.VMs {
+			vm := vms[ref]
+			ch <- prometheus.MustNewConstMetric(self.vmppVM, prometheus.GaugeValue, 1, vmpp.UUID, vmpp.NameLabel, vm.UUID, vm.NameLabel)
+		}
+	}
+	return nil
+}
+
+func (self *ScheduleCollector) vmppJob(ch chan<- prometheus.Metric, vmpp xenapi.VMPPRecord, job string, frequency string, lastRun time.Time, interval time.Duration, now time.Time) {
+	labels := []string{vmpp.UUID, vmpp.NameLabel, job, frequency}
+	ch <- prometheus.MustNewConstMetric(self.vmppLastRun, prometheus.GaugeValue, float64(lastRun.Unix()), labels...)
+	if interval == 0 {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(self.vmppInterval, prometheus.GaugeValue, interval.Seconds(), labels...)
+	if vmpp.IsPolicyEnabled {
+		ch <- prometheus.MustNewConstMetric(self.vmppOverdue, prometheus.GaugeValue, overdue(now, lastRun, interval), labels...)
+	}
+}
+
+func overdue(now time.Time, lastRun time.Time, interval time.Duration) float64 {
+	return math.Max(0, (now.Sub(lastRun) - interval).Seconds())
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	registerCollector("session", NewSessionCollector)
+}
+
+// SessionCollector reports how long ago the XenAPI session logged in and how
+// often it had to log in again, e.g. after xapi restarted.
+type SessionCollector struct {
+	xen      *SammXen
+	age      *prometheus.Desc
+	relogins *prometheus.Desc
+}
+
+func NewSessionCollector(xen *SammXen) Collector {
+	return &SessionCollector{
+		xen: xen,
+		age: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "session", "age_seconds"),
+			"Time since the XenAPI session logged in.",
+			nil, nil,
+		),
+		relogins: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "session", "relogins_total"),
+			"Number of times the XenAPI session logged in again after it became invalid.",
+			nil, nil,
+		),
+	}
+}
+
+func (self *SessionCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(self.age, prometheus.GaugeValue, time.Since(self.xen.Session.LoginTime()).Seconds())
+	ch <- prometheus.MustNewConstMetric(self.relogins, prometheus.CounterValue, float64(self.xen.Session.Relogins()))
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+var (
+	srProbeHealth         = flag.Bool("collector.sr.probe-health", false, "Run SR.probe_ext in the background to report the health of SRs whose driver supports it (SMAPIv3 drivers such as gfs2). The probe scans the storage backend.")
+	srProbeHealthInterval = flag.Duration("collector.sr.probe-health-interval", 5*time.Minute, "Interval between two runs of SR.probe_ext on the SRs.")
+)
+
+func init() {
+	registerCollector("sr", NewSRCollector)
+}
+
+var srHealthStates = []xenapi.SrHealth{
+	xenapi.SrHealthHealthy,
+	xenapi.SrHealthRecovering,
+	xenapi.SrHealthUnreachable,
+	xenapi.SrHealthUnavailable,
+}
+
+// SRCollector reports the capacity and allocation of every storage repository.
+type SRCollector struct {
+	xen                 *SammXen
+	physicalSize        *prometheus.Desc
+	physicalUtilisation *prometheus.Desc
+	virtualAllocation   *prometheus.Desc
+	overprovisioning    *prometheus.Desc
+	health              *prometheus.Desc
+
+	// Results of the last health probe, by SR UUID
+	mu        sync.Mutex
+	probing   bool
+	lastProbe time.Time
+	healths   map[string]xenapi.SrHealth
+}
+
+func NewSRCollector(xen *SammXen) Collector {
+	labels := []string{"sr_uuid", "sr", "type", "content_type", "shared"}
+	return &SRCollector{
+		xen: xen,
+		physicalSize: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sr", "physical_size_bytes"),
+			"Total physical size of the SR.",
+			labels, nil,
+		),
+		physicalUtilisation: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sr", "physical_utilisation_bytes"),
+			"Physical space currently used on the SR.",
+			labels, nil,
+		),
+		virtualAllocation: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sr", "virtual_allocation_bytes"),
+			"Sum of the virtual sizes of all VDIs in the SR.",
+			labels, nil,
+		),
+		overprovisioning: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sr", "overprovisioning_ratio"),
+			"Virtual allocation divided by physical size of the SR.",
+			labels, nil,
+		),
+		health: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "sr", "health"),
+			"Health of the SR as reported by SR.probe_ext, 1 for the current state.",
+			append(labels, "state"), nil,
+		),
+	}
+}
+
+func (self *SRCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	srs, err := xen.SRs()
+	if err != nil {
+		return err
+	}
+	var healths map[string]xenapi.SrHealth
+	if *srProbeHealth {
+		healths = self.refreshHealth()
+	}
+
+	for _, sr := range srs {
+		labels := []string{sr.UUID, sr.NameLabel, sr.Type, sr.ContentType, strconv.FormatBool(sr.Shared)}
+		ch <- prometheus.MustNewConstMetric(self.physicalSize, prometheus.GaugeValue, float64(sr.PhysicalSize), labels...)
+		ch <- prometheus.MustNewConstMetric(self.physicalUtilisation, prometheus.GaugeValue, float64(sr.PhysicalUtilisation), labels...)
+		ch <- prometheus.MustNewConstMetric(self.virtualAllocation, prometheus.GaugeValue, float64(sr.VirtualAllocation), labels...)
+		if sr.PhysicalSize > 0 {
+			ch <- prometheus.MustNewConstMetric(self.overprovisioning, prometheus.GaugeValue, float64(sr.VirtualAllocation)/float64(sr.PhysicalSize), labels...)
+		}
+		health, ok := healths[sr.UUID]
+		if !ok {
+			continue
+		}
+		for _, state := range srHealthStates {
+			ch <- prometheus.MustNewConstMetric(self.health, prometheus.GaugeValue, boolToFloat(health == state), append(labels, string(state))...)
+		}
+	}
+	return nil
+}
+
+// refreshHealth returns the results of the last health probe, and starts a new
+// probe in the background once they are older than the probe interval.
+// SR.probe_ext can be slow, so it is kept off the scrape path.
+func (self *SRCollector) refreshHealth() map[string]xenapi.SrHealth {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if !self.probing && time.Since(self.lastProbe) >= *srProbeHealthInterval {
+		self.probing = true
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), *srProbeHealthInterval)
+			defer cancel()
+			healths, err := self.probeHealths(self.xen.WithContext(ctx))
+			if err != nil {
+				log.Printf("SR health probe: %s", err)
+			}
+			self.mu.Lock()
+			defer self.mu.Unlock()
+			self.probing = false
+			self.lastProbe = time.Now()
+			if err == nil {
+				self.healths = healths
+			}
+		}()
+	}
+	return self.healths
+}
+
+func (self *SRCollector) probeHealths(xen *SammXen) (map[string]xenapi.SrHealth, error) {
+	srs, err := xen.SRs()
+	if err != nil {
+		return nil, err
+	}
+	pbds, err := xenapi.PBD.GetAllRecords(xen.Session)
+	if err != nil {
+		return nil, fmt.Errorf("PBD.get_all_records: %w", err)
+	}
+	sms, err := xenapi.SM.GetAllRecords(xen.Session)
+	if err != nil {
+		return nil, fmt.Errorf("SM.get_all_records: %w", err)
+	}
+	probeExt := make(map[string]bool)
+	for _, sm := range sms {
+		if smProbeExt(sm) {
+			probeExt[sm.Type] = true
+		}
+	}
+	healths := make(map[string]xenapi.SrHealth)
+	for _, sr := range srs {
+		if !probeExt[sr.Type] {
+			continue
+		}
+		if health, ok := self.probeHealth(xen, sr, pbds); ok {
+			healths[sr.UUID] = health
+		}
+	}
+	return healths, nil
+}
+
+// smProbeExt returns whether the driver implements SR.probe_ext, which only
+// SMAPIv3 drivers do. These require version 5.0 or later of the storage API.
+func smProbeExt(sm xenapi.SMRecord) bool {
+	major, _, _ := strings.Cut(sm.RequiredAPIVersion, ".")
+	version, err := strconv.Atoi(major)
+	return err == nil && version >= 5
+}
+
+// probeHealth runs SR.probe_ext with the device config of an attached PBD of
+// the SR. If the probe fails, it is logged and the SR skipped.
+func (self *SRCollector) probeHealth(xen *SammXen, sr xenapi.SRRecord, pbds map[xenapi.PBDRef]xenapi.PBDRecord) (xenapi.SrHealth, bool) {
+	for _, ref := range sr.PBDs {
+		pbd, ok := pbds[ref]
+		if !ok || !pbd.CurrentlyAttached {
+			continue
+		}
+		results, err := xenapi.SR.ProbeExt(xen.Session, pbd.Host, pbd.DeviceConfig, sr.Type, sr.SmConfig)
+		if err != nil {
+			log.Printf("SR.probe_ext on SR %s: %s", sr.UUID, err)
+			return "", false
+		}
+		for _, result := range results {
+			if result.Sr != nil && result.Sr.UUID != nil && *result.Sr.UUID == sr.UUID {
+				return result.Sr.Health, true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("task", NewTaskCollector)
+}
+
+// TaskCollector reports the tasks of the pool: how many are pending, failed
+// or cancelled, the progress of the pending ones and how long the finished
+// ones took.
+type TaskCollector struct {
+	xen *SammXen
+	mu  sync.Mutex
+	// Finished tasks already observed in durations
+	observed  map[string]bool
+	tasks     *prometheus.Desc
+	progress  *prometheus.Desc
+	durations *prometheus.HistogramVec
+}
+
+func NewTaskCollector(xen *SammXen) Collector {
+	return &TaskCollector{
+		xen:      xen,
+		observed: make(map[string]bool),
+		tasks: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "tasks"),
+			"Number of pending, failed or cancelled tasks.",
+			[]string{"status", "type", "name"}, nil,
+		),
+		progress: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "task", "progress_ratio"),
+			"Progress of a pending task, between 0 and 1.",
+			[]string{"task_uuid", "type", "name"}, nil,
+		),
+		durations: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: namespace,
+				Subsystem: "task",
+				Name:      "duration_seconds",
+				Help:      "Time between creation and completion of finished tasks.",
+				Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200},
+			},
+			[]string{"operation", "status"},
+		),
+	}
+}
+
+func (self *TaskCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	tasks, err := xenapi.Task.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("task.get_all_records: %w", err)
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	type taskKey struct {
+		status   xenapi.TaskStatusType
+		taskType string
+		name     string
+	}
+	counts := make(map[taskKey]float64)
+	present := make(map[string]bool, len(tasks))
+	for _, task := range tasks {
+		present[task.UUID] = true
+		switch task.Status {
+		case xenapi.TaskStatusTypePending:
+			ch <- prometheus.MustNewConstMetric(self.progress, prometheus.GaugeValue, task.Progress, task.UUID, task.Type, task.NameLabel)
+			counts[taskKey{task.Status, task.Type, task.NameLabel}]++
+		case xenapi.TaskStatusTypeFailure, xenapi.TaskStatusTypeCancelling, xenapi.TaskStatusTypeCancelled:
+			counts[taskKey{task.Status, task.Type, task.NameLabel}]++
+		}
+		if task.Status != xenapi.TaskStatusTypePending && task.Status != xenapi.TaskStatusTypeCancelling &&
+			!task.Finished.IsZero() && !self.observed[task.UUID] {
+			self.observed[task.UUID] = true
+			self.durations.WithLabelValues(task.NameLabel, string(task.Status)).Observe(task.Finished.Sub(task.Created).Seconds())
+		}
+	}
+	// Completed tasks are eventually destroyed by XAPI
+	for uuid := range self.observed {
+		if !present[uuid] {
+			delete(self.observed, uuid)
+		}
+	}
+
+	for key, count := range counts {
+		ch <- prometheus.MustNewConstMetric(self.tasks, prometheus.GaugeValue, count, string(key.status), key.taskType, key.name)
+	}
+	self.durations.Collect(ch)
+	return nil
+}
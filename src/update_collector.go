@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("update", NewUpdateCollector)
+}
+
+var latestSyncedUpdatesAppliedStates = []xenapi.LatestSyncedUpdatesAppliedState{
+	xenapi.LatestSyncedUpdatesAppliedStateYes,
+	xenapi.LatestSyncedUpdatesAppliedStateNo,
+	xenapi.LatestSyncedUpdatesAppliedStateUnknown,
+}
+
+// UpdateCollector reports which hosts are behind on updates or waiting for a
+// reboot, and the state of the update repositories of the pool.
+type UpdateCollector struct {
+	xen                *SammXen
+	requiringReboot    *prometheus.Desc
+	pendingGuidance    *prometheus.Desc
+	latestApplied      *prometheus.Desc
+	lastSoftwareUpdate *prometheus.Desc
+	applied            *prometheus.Desc
+	syncEnabled        *prometheus.Desc
+	lastSync           *prometheus.Desc
+	repositoryUpToDate *prometheus.Desc
+}
+
+func NewUpdateCollector(xen *SammXen) Collector {
+	labels := []string{"host_uuid", "hostname"}
+	poolLabels := []string{"pool_name", "pool_uuid"}
+	return &UpdateCollector{
+		xen: xen,
+		requiringReboot: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "updates_requiring_reboot"),
+			"Number of applied updates that need a reboot of the host to take effect.",
+			labels, nil,
+		),
+		pendingGuidance: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "pending_guidance"),
+			"Guidance pending on the host after applying updates. Level is mandatory, recommended or full.",
+			append(labels, "guidance", "level"), nil,
+		),
+		latestApplied: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "latest_synced_updates_applied"),
+			"Whether the latest updates synced from the repositories are applied on the host, 1 for the current state.",
+			append(labels, "state"), nil,
+		),
+		lastSoftwareUpdate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "last_software_update_timestamp_seconds"),
+			"Time of the last software update applied on the host.",
+			labels, nil,
+		),
+		applied: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "host", "update_applied_info"),
+			"Pool update applied on the host.",
+			append(labels, "update", "version"), nil,
+		),
+		syncEnabled: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "update_sync_enabled"),
+			"Whether periodic synchronisation of the update repositories is enabled.",
+			poolLabels, nil,
+		),
+		lastSync: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "pool", "last_update_sync_timestamp_seconds"),
+			"Time of the last synchronisation of the update repositories.",
+			poolLabels, nil,
+		),
+		repositoryUpToDate: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "repository", "up_to_date"),
+			"Whether the pool is up to date with the update repository.",
+			append(poolLabels, "repository", "origin"), nil,
+		),
+	}
+}
+
+func (self *UpdateCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	pool, err := xen.Pool()
+	if err != nil {
+		return err
+	}
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+	updates, err := xenapi.PoolUpdate.GetAllRecords(xen.Session)
+	if err != nil {
+		return fmt.Errorf("pool_update.get_all_records: %w", err)
+	}
+
+	for _, host := range hosts {
+		labels := []string{host.UUID, host.Hostname}
+		ch <- prometheus.MustNewConstMetric(self.requiringReboot, prometheus.GaugeValue, float64(len(host.UpdatesRequiringReboot)), labels...)
+		for level, guidances := range map[string][]xenapi.UpdateGuidances{
+			"mandatory":   host.PendingGuidances,
+			"recommended": host.PendingGuidancesRecommended,
+			"full":        host.PendingGuidancesFull,
+		} {
+			for _, guidance := range guidances {
+				ch <- prometheus.MustNewConstMetric(self.pendingGuidance, prometheus.GaugeValue, 1, append(labels, string(guidance), level)...)
+			}
+		}
+		for _, state := range latestSyncedUpdatesAppliedStates {
+			ch <- prometheus.MustNewConstMetric(self.latestApplied, prometheus.GaugeValue, boolToFloat(host.LatestSyncedUpdatesApplied == state), append(labels, string(state))...)
+		}
+		if !host.LastSoftwareUpdate.IsZero() {
+			ch <- prometheus.MustNewConstMetric(self.lastSoftwareUpdate, prometheus.GaugeValue, float64(host.LastSoftwareUpdate.Unix()), labels...)
+		}
+		for _, ref := range host.Updates {
+			update, ok := updates[ref]
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(self.applied, prometheus.GaugeValue, 1, append(labels, update.NameLabel, update.Version)...)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(self.syncEnabled, prometheus.GaugeValue, boolToFloat(pool.UpdateSyncEnabled), pool.NameLabel, pool.UUID)
+	if !pool.LastUpdateSync.IsZero() {
+		ch <- prometheus.MustNewConstMetric(self.lastSync, prometheus.GaugeValue, float64(pool.LastUpdateSync.Unix()), pool.NameLabel, pool.UUID)
+	}
+	for _, ref := range pool.Repositories {
+		repository, err := xenapi.Repository.GetRecord(xen.Session, ref)
+		// Removed since the pool record was read
+		if errors.Is(err, xenapi.ErrHandleInvalid) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Repository.get_record: %w", err)
+		}
+		ch <- prometheus.MustNewConstMetric(self.repositoryUpToDate, prometheus.GaugeValue, boolToFloat(repository.UpToDate),
+			pool.NameLabel, pool.UUID, repository.NameLabel, string(repository.Origin))
+	}
+	return nil
+}
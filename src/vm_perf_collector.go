@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"xenapi"
+)
+
+func init() {
+	registerCollector("vm_perf", NewVMPerfCollector)
+}
+
+var (
+	rrdCPURegexp = regexp.MustCompile(`^cpu(\d+)$`)
+	rrdVBDRegexp = regexp.MustCompile(`^vbd_([a-z0-9]+)_(read|write)(_latency)?$`)
+	rrdVIFRegexp = regexp.MustCompile(`^vif_(\d+)_(rx|tx)$`)
+)
+
+// rrdHostState remembers, per host, the end of the last rrd_updates window
+// and the newest value of every VM data source seen in it.
+type rrdHostState struct {
+	last   time.Time
+	values map[rrdKey]float64
+}
+
+type rrdKey struct {
+	vmUUID string
+	name   string
+}
+
+// VMPerfCollector reports per-VM CPU, memory, disk and network usage from the
+// RRDs of every host. Only the rows added since the previous scrape are
+// downloaded.
+type VMPerfCollector struct {
+	xen   *SammXen
+	mu    sync.Mutex
+	hosts map[string]*rrdHostState
+
+	cpu                *prometheus.Desc
+	memoryTarget       *prometheus.Desc
+	memoryInternalFree *prometheus.Desc
+	vbdBytes           *prometheus.Desc
+	vbdLatency         *prometheus.Desc
+	vifBytes           *prometheus.Desc
+}
+
+func NewVMPerfCollector(xen *SammXen) Collector {
+	labels := []string{"vm_uuid", "vm", "host_uuid", "host"}
+	return &VMPerfCollector{
+		xen:   xen,
+		hosts: make(map[string]*rrdHostState),
+		cpu: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm", "cpu_usage_ratio"),
+			"Usage of each vCPU of the VM, between 0 and 1.",
+			append(labels, "cpu"), nil,
+		),
+		memoryTarget: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm", "memory_target_bytes"),
+			"Memory target of the VM balloon driver.",
+			labels, nil,
+		),
+		memoryInternalFree: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm", "memory_internal_free_bytes"),
+			"Free memory as reported by the guest agent.",
+			labels, nil,
+		),
+		vbdBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm", "vbd_bytes_per_second"),
+			"Read or write throughput of each virtual disk.",
+			append(labels, "device", "mode"), nil,
+		),
+		vbdLatency: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm", "vbd_latency_seconds"),
+			"Read or write latency of each virtual disk.",
+			append(labels, "device", "mode"), nil,
+		),
+		vifBytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "vm", "vif_bytes_per_second"),
+			"Receive or transmit throughput of each virtual network interface.",
+			append(labels, "vif", "direction"), nil,
+		),
+	}
+}
+
+func (self *VMPerfCollector) Update(ctx context.Context, ch chan<- prometheus.Metric) error {
+	xen := self.xen.WithContext(ctx)
+	hosts, err := xen.Hosts()
+	if err != nil {
+		return err
+	}
+	vms, err := xen.VMs()
+	if err != nil {
+		return err
+	}
+	vmsByUUID := make(map[string]xenapi.VMRecord, len(vms))
+	for _, vm := range vms {
+		vmsByUUID[vm.UUID] = vm
+	}
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	present := make(map[string]bool, len(hosts))
+	var (
+		wg   sync.WaitGroup
+		emu  sync.Mutex
+		errs []error
+	)
+	for _, host := range hosts {
+		present[host.UUID] = true
+		state, ok := self.hosts[host.UUID]
+		if !ok {
+			state = &rrdHostState{last: time.Now().Add(-time.Minute)}
+			self.hosts[host.UUID] = state
+		}
+		wg.Add(1)
+		go func(host xenapi.HostRecord, state *rrdHostState) {
+			defer wg.Done()
+			if err := self.refresh(xen, host, state); err != nil {
+				emu.Lock()
+				errs = append(errs, fmt.Errorf("host %s: %w", host.NameLabel, err))
+				emu.Unlock()
+			}
+		}(host, state)
+	}
+	wg.Wait()
+
+	for uuid := range self.hosts {
+		if !present[uuid] {
+			delete(self.hosts, uuid)
+		}
+	}
+	for _, host := range hosts {
+		for key, value := range self.hosts[host.UUID].values {
+			vm, ok := vmsByUUID[key.vmUUID]
+			if !ok {
+				continue
+			}
+			self.emit(ch, key.name, value, vm.UUID, vm.NameLabel, host.UUID, host.NameLabel)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// refresh downloads the rows added since the previous call and keeps the
+// newest value of every VM data source. If no row was added, the values of
+// the previous window are kept.
+func (self *VMPerfCollector) refresh(xen *SammXen, host xenapi.HostRecord, state *rrdHostState) error {
+	result, err := xen.GetHostUpdatesRrd(host.Address, state.last)
+	if err != nil {
+		return err
+	}
+	if len(result.Rows) == 0 {
+		return nil
+	}
+	values := make(map[rrdKey]float64, len(result.Legend))
+	for column, entry := range result.Legend {
+		if entry.ObjectType != "vm" {
+			continue
+		}
+		for _, row := range result.Rows {
+			if !math.IsNaN(row.Values[column]) {
+				values[rrdKey{vmUUID: entry.UUID, name: entry.Name}] = row.Values[column]
+				break
+			}
+		}
+	}
+	state.values = values
+	state.last = result.End
+	return nil
+}
+
+func (self *VMPerfCollector) emit(ch chan<- prometheus.Metric, name string, value float64, labels ...string) {
+	switch {
+	case name == "memory_target":
+		ch <- prometheus.MustNewConstMetric(self.memoryTarget, prometheus.GaugeValue, value, labels...)
+	case name == "memory_internal_free":
+		// Reported in KiB
+		ch <- prometheus.MustNewConstMetric(self.memoryInternalFree, prometheus.GaugeValue, value*1024, labels...)
+	case rrdCPURegexp.MatchString(name):
+		m := rrdCPURegexp.FindStringSubmatch(name)
+		ch <- prometheus.MustNewConstMetric(self.cpu, prometheus.GaugeValue, value, append(labels, m[1])...)
+	case rrdVBDRegexp.MatchString(name):
+		m := rrdVBDRegexp.FindStringSubmatch(name)
+		if m[3] == "" {
+			ch <- prometheus.MustNewConstMetric(self.vbdBytes, prometheus.GaugeValue, value, append(labels, m[1], m[2])...)
+		} else {
+			// Reported in microseconds
+			ch <- prometheus.MustNewConstMetric(self.vbdLatency, prometheus.GaugeValue, value/1e6, append(labels, m[1], m[2])...)
+		}
+	case rrdVIFRegexp.MatchString(name):
+		m := rrdVIFRegexp.FindStringSubmatch(name)
+		ch <- prometheus.MustNewConstMetric(self.vifBytes, prometheus.GaugeValue, value, append(labels, m[1], m[2])...)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// emitted returns the metrics emit reports for a data source.
+func emitted(t *testing.T, collector *VMPerfCollector, name string, value float64) []*dto.Metric {
+	t.Helper()
+	ch := make(chan prometheus.Metric, 1)
+	collector.emit(ch, name, value, "vm-uuid", "vm", "host-uuid", "host")
+	close(ch)
+	var metrics []*dto.Metric
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatal(err)
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestVMPerfEmit(t *testing.T) {
+	collector := NewVMPerfCollector(nil).(*VMPerfCollector)
+	tests := []struct {
+		name   string
+		value  float64
+		want   float64
+		labels map[string]string
+	}{
+		{"memory_target", 1 << 30, 1 << 30, nil},
+		{"memory_internal_free", 512, 512 * 1024, nil},
+		{"cpu3", 0.5, 0.5, map[string]string{"cpu": "3"}},
+		{"vbd_xvda_read", 4096, 4096, map[string]string{"device": "xvda", "mode": "read"}},
+		{"vbd_xvdb_write_latency", 250, 0.00025, map[string]string{"device": "xvdb", "mode": "write"}},
+		{"vif_1_tx", 100, 100, map[string]string{"vif": "1", "direction": "tx"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics := emitted(t, collector, test.name, test.value)
+			if len(metrics) != 1 {
+				t.Fatalf("expected a single metric, got %d", len(metrics))
+			}
+			if value := metrics[0].GetGauge().GetValue(); value != test.want {
+				t.Fatalf("expected %v, got %v", test.want, value)
+			}
+			labels := make(map[string]string)
+			for _, pair := range metrics[0].GetLabel() {
+				labels[pair.GetName()] = pair.GetValue()
+			}
+			if labels["host_uuid"] != "host-uuid" || labels["vm_uuid"] != "vm-uuid" {
+				t.Fatalf("expected the VM and host UUIDs, got %v", labels)
+			}
+			for name, value := range test.labels {
+				if labels[name] != value {
+					t.Fatalf("expected %s=%q, got %v", name, value, labels)
+				}
+			}
+		})
+	}
+
+	// Data sources of no known metric are skipped
+	for _, name := range []string{"cpu_usage", "vbd_xvda_iops_read", "vif_eth0_rx", "memory"} {
+		if metrics := emitted(t, collector, name, 1); len(metrics) != 0 {
+			t.Fatalf("expected %s to be skipped, got %v", name, metrics)
+		}
+	}
+}
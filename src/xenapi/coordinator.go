@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// rpcTarget is the address the calls of a session are sent to. It is shared
+// by the copies of the session, so that following the pool coordinator to
+// another host applies to all of them.
+type rpcTarget struct {
+	mu      sync.RWMutex
+	baseURL string
+	// Addresses of the pool members, to look for the coordinator on when it
+	// cannot be reached, and why they could not be fetched last time if so
+	members    []string
+	membersErr error
+}
+
+func (target *rpcTarget) url() string {
+	target.mu.RLock()
+	defer target.mu.RUnlock()
+	return target.baseURL
+}
+
+func (target *rpcTarget) endpoint() string {
+	return target.url() + "/jsonrpc"
+}
+
+// addresses returns the address of the host calls are sent to, with and
+// without port.
+func (target *rpcTarget) addresses() []string {
+	u, err := url.Parse(target.url())
+	if err != nil {
+		return nil
+	}
+	return []string{u.Hostname(), u.Host}
+}
+
+// moveTo sends the calls to the host at address from now on, keeping the
+// scheme and port. The address may also carry its own port.
+func (target *rpcTarget) moveTo(address string) error {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	u, err := url.Parse(target.baseURL)
+	if err != nil {
+		return err
+	}
+	u.Host = urlHost(address, u.Port())
+	target.baseURL = u.String()
+	return nil
+}
+
+func (target *rpcTarget) setMembers(members []string) {
+	target.mu.Lock()
+	target.members, target.membersErr = members, nil
+	target.mu.Unlock()
+}
+
+func (target *rpcTarget) setMembersErr(err error) {
+	target.mu.Lock()
+	target.membersErr = err
+	target.mu.Unlock()
+}
+
+// nextMember returns a pool member not tried yet.
+func (target *rpcTarget) nextMember(tried map[string]bool) (string, bool) {
+	target.mu.RLock()
+	defer target.mu.RUnlock()
+	for _, member := range target.members {
+		if !tried[member] {
+			return member, true
+		}
+	}
+	return "", false
+}
+
+// sendCallToCoordinator makes the call on the pool coordinator. A host that is not the
+// coordinator answers HOST_IS_SLAVE with the address of the coordinator, so
+// the call is sent again there. When the host cannot be reached at all, the
+// call is sent to the other pool members in turn to find the new coordinator,
+// e.g. after an HA failover. The pool members are fetched after logging in and
+// after a failover.
+func (client *rpcClient) sendCallToCoordinator(methodName string, params ...interface{}) (result interface{}, err error) {
+	tried := make(map[string]bool)
+	for _, address := range client.target.addresses() {
+		tried[address] = true
+	}
+	failover := false
+	for {
+		result, err = client.sendCallOnce(methodName, params...)
+		address, unreachable, ok := client.redirection(err, tried)
+		if !ok {
+			break
+		}
+		if moveErr := client.target.moveTo(address); moveErr != nil {
+			break
+		}
+		tried[address] = true
+		failover = failover || unreachable
+	}
+	if err != nil {
+		return
+	}
+	method := strings.TrimPrefix(methodName, "Async.")
+	switch {
+	case method == "session.login_with_password":
+		if ref, ok := result.(string); ok {
+			client.refreshMembers(ref)
+		}
+	case failover && len(params) > 0:
+		if ref, ok := params[0].(string); ok {
+			client.refreshMembers(ref)
+		}
+	}
+	return
+}
+
+// redirection returns the address to send a failed call to next, if any, and
+// whether the host the call was sent to could not be reached.
+func (client *rpcClient) redirection(err error, tried map[string]bool) (string, bool, bool) {
+	if err == nil || client.context().Err() != nil {
+		return "", false, false
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code == ErrorHostIsSlave && len(apiErr.Params) > 0 {
+		coordinator := apiErr.Params[0]
+		// Give up on coordinators pointing at each other
+		if tried[coordinator] {
+			return "", false, false
+		}
+		return coordinator, false, true
+	}
+	// Only calls that never reached the host are safe to send elsewhere
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		member, ok := client.target.nextMember(tried)
+		return member, true, ok
+	}
+	return "", false, false
+}
+
+// refreshMembers records the addresses of the pool members to look for the
+// coordinator on if it goes away. The list is only a fallback, so failing to
+// fetch it does not fail the call: the previous list is kept, and the error
+// returned by PoolMembers.
+func (client *rpcClient) refreshMembers(ref string) {
+	result, err := client.sendCallOnce("host.get_all_records", ref)
+	if err == nil {
+		var hosts map[HostRef]HostRecord
+		hosts, err = deserializeHostRefToHostRecordMap("host.get_all_records", result)
+		if err == nil {
+			members := make([]string, 0, len(hosts))
+			for _, host := range hosts {
+				if host.Address != "" {
+					members = append(members, host.Address)
+				}
+			}
+			client.target.setMembers(members)
+			return
+		}
+	}
+	client.target.setMembersErr(fmt.Errorf("could not fetch the pool members: %w", err))
+}
+
+// PoolMembers returns the addresses of the pool members the calls of the
+// session fail over to when the coordinator cannot be reached, and the error
+// of the last attempt to fetch them, if it failed.
+func (class *Session) PoolMembers() ([]string, error) {
+	target := class.client.target
+	target.mu.RLock()
+	defer target.mu.RUnlock()
+	return append([]string(nil), target.members...), target.membersErr
+}
+
+// URL returns the base URL of the host the calls of the session are sent to,
+// the pool coordinator once a call has been redirected to it.
+func (class *Session) URL() string {
+	return class.client.target.url()
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go/xenapi"
+)
+
+func TestCoordinatorRedirection(t *testing.T) {
+	coordinator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":["OpaqueRef:1"]}`))
+	}))
+	defer coordinator.Close()
+	memberCalls := 0
+	member := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		memberCalls++
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"error":{"code":1,"message":"HOST_IS_SLAVE","data":["` + coordinator.Listener.Addr().String() + `"]}}`))
+	}))
+	defer member.Close()
+	// An address nothing listens on, like a coordinator that went down
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := listener.Addr().String()
+	listener.Close()
+
+	session := xenapi.NewSession(&xenapi.ClientOpts{URL: "http://" + down})
+	xenapi.SetPoolMembers(session, []string{down, member.Listener.Addr().String()})
+	for i := 0; i < 2; i++ {
+		hosts, err := xenapi.Host.GetAll(session)
+		if err != nil || len(hosts) != 1 {
+			t.Fatalf("expected the call to reach the coordinator, got %v, %v", hosts, err)
+		}
+	}
+	if session.URL() != coordinator.URL || memberCalls != 1 {
+		t.Fatalf("expected to stay on the coordinator %s, got %s after %d calls to the member", coordinator.URL, session.URL(), memberCalls)
+	}
+
+	member.Close()
+	coordinator.Close()
+	if _, err := xenapi.Host.GetAll(session); err == nil {
+		t.Fatalf("expected the call to fail once every member is down, got %v", err)
+	}
+}
+
+func TestCoordinatorMembersFromLogin(t *testing.T) {
+	member := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":["OpaqueRef:1"]}`))
+	}))
+	defer member.Close()
+	results := map[string]string{
+		"session.login_with_password": `"OpaqueRef:session"`,
+		"pool.get_all":                `["OpaqueRef:pool"]`,
+		"pool.get_record":             `{"master":"OpaqueRef:host"}`,
+		"host.get_record":             `{"API_version_major":2,"API_version_minor":21,"software_version":{"xapi":"24.0"}}`,
+		"host.get_all_records":        `{"OpaqueRef:member":{"address":"` + member.Listener.Addr().String() + `"}}`,
+	}
+	coordinator := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request xenapi.Request
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":` + results[request.Method] + `}`))
+	}))
+	// Like a host that went down, rather than one closing an idle connection
+	coordinator.Config.SetKeepAlivesEnabled(false)
+	coordinator.Start()
+
+	session := xenapi.NewSession(&xenapi.ClientOpts{URL: coordinator.URL})
+	if _, err := session.LoginWithPassword("root", "secret", "1.0", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if members, err := session.PoolMembers(); err != nil || len(members) != 1 {
+		t.Fatalf("expected the member to be fetched at login, got %v, %v", members, err)
+	}
+	coordinator.Close()
+	if hosts, err := xenapi.Host.GetAll(session); err != nil || len(hosts) != 1 {
+		t.Fatalf("expected the call to fail over to the member learnt at login, got %v, %v", hosts, err)
+	}
+	if session.URL() != member.URL {
+		t.Fatalf("expected to move to %s, got %s", member.URL, session.URL())
+	}
+	// The member answers host.get_all_records with a list
+	if _, err := session.PoolMembers(); err == nil {
+		t.Fatal("expected the members not to be fetched again from the member")
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi
+
+// ErrorCode is the name of a XenAPI error. It implements error so that an
+// *APIError can be matched with errors.Is against the sentinels below, or
+// against any of the Error constants converted to an ErrorCode:
+//
+//	errors.Is(err, xenapi.ErrorCode(xenapi.ErrorVMIsTemplate))
+type ErrorCode string
+
+func (code ErrorCode) Error() string {
+	return string(code)
+}
+
+// Sentinels of the errors callers commonly handle
+const (
+	ErrEventFromTokenParseFailure  ErrorCode = ErrorEventFromTokenParseFailure
+	ErrEventsLost                  ErrorCode = ErrorEventsLost
+	ErrHandleInvalid               ErrorCode = ErrorHandleInvalid
+	ErrHostIsSlave                 ErrorCode = ErrorHostIsSlave
+	ErrHostOffline                 ErrorCode = ErrorHostOffline
+	ErrHostStillBooting            ErrorCode = ErrorHostStillBooting
+	ErrInternalError               ErrorCode = ErrorInternalError
+	ErrMessageMethodUnknown        ErrorCode = ErrorMessageMethodUnknown
+	ErrOperationNotAllowed         ErrorCode = ErrorOperationNotAllowed
+	ErrPermissionDenied            ErrorCode = ErrorPermissionDenied
+	ErrRbacPermissionDenied        ErrorCode = ErrorRbacPermissionDenied
+	ErrSessionAuthenticationFailed ErrorCode = ErrorSessionAuthenticationFailed
+	ErrSessionInvalid              ErrorCode = ErrorSessionInvalid
+	ErrSrOperationNotSupported     ErrorCode = ErrorSrOperationNotSupported
+	ErrVMBadPowerState             ErrorCode = ErrorVMBadPowerState
+)
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi
+
+import (
+	"strings"
+)
+
+type snapshotDeserializer func(context string, input interface{}) (RecordInterface, error)
+
+func deserializeSnapshotAs[T any](deserialize func(context string, input interface{}) (T, error)) snapshotDeserializer {
+	return func(context string, input interface{}) (RecordInterface, error) {
+		return deserialize(context, input)
+	}
+}
+
+// snapshotDeserializers maps the lower case class names found in events to
+// the deserializer of their records.
+var snapshotDeserializers = map[string]snapshotDeserializer{
+	"blob":              deserializeSnapshotAs(deserializeBlobRecord),
+	"bond":              deserializeSnapshotAs(deserializeBondRecord),
+	"certificate":       deserializeSnapshotAs(deserializeCertificateRecord),
+	"cluster":           deserializeSnapshotAs(deserializeClusterRecord),
+	"cluster_host":      deserializeSnapshotAs(deserializeClusterHostRecord),
+	"console":           deserializeSnapshotAs(deserializeConsoleRecord),
+	"crashdump":         deserializeSnapshotAs(deserializeCrashdumpRecord),
+	"dr_task":           deserializeSnapshotAs(deserializeDRTaskRecord),
+	"driver_variant":    deserializeSnapshotAs(deserializeDriverVariantRecord),
+	"feature":           deserializeSnapshotAs(deserializeFeatureRecord),
+	"gpu_group":         deserializeSnapshotAs(deserializeGPUGroupRecord),
+	"host":              deserializeSnapshotAs(deserializeHostRecord),
+	"host_cpu":          deserializeSnapshotAs(deserializeHostCPURecord),
+	"host_crashdump":    deserializeSnapshotAs(deserializeHostCrashdumpRecord),
+	"host_driver":       deserializeSnapshotAs(deserializeHostDriverRecord),
+	"host_metrics":      deserializeSnapshotAs(deserializeHostMetricsRecord),
+	"host_patch":        deserializeSnapshotAs(deserializeHostPatchRecord),
+	"lvhd":              deserializeSnapshotAs(deserializeLVHDRecord),
+	"message":           deserializeSnapshotAs(deserializeMessageRecord),
+	"network":           deserializeSnapshotAs(deserializeNetworkRecord),
+	"network_sriov":     deserializeSnapshotAs(deserializeNetworkSriovRecord),
+	"observer":          deserializeSnapshotAs(deserializeObserverRecord),
+	"pbd":               deserializeSnapshotAs(deserializePBDRecord),
+	"pci":               deserializeSnapshotAs(deserializePCIRecord),
+	"pgpu":              deserializeSnapshotAs(deserializePGPURecord),
+	"pif":               deserializeSnapshotAs(deserializePIFRecord),
+	"pif_metrics":       deserializeSnapshotAs(deserializePIFMetricsRecord),
+	"pool":              deserializeSnapshotAs(deserializePoolRecord),
+	"pool_patch":        deserializeSnapshotAs(deserializePoolPatchRecord),
+	"pool_update":       deserializeSnapshotAs(deserializePoolUpdateRecord),
+	"pusb":              deserializeSnapshotAs(deserializePUSBRecord),
+	"pvs_cache_storage": deserializeSnapshotAs(deserializePVSCacheStorageRecord),
+	"pvs_proxy":         deserializeSnapshotAs(deserializePVSProxyRecord),
+	"pvs_server":        deserializeSnapshotAs(deserializePVSServerRecord),
+	"pvs_site":          deserializeSnapshotAs(deserializePVSSiteRecord),
+	"repository":        deserializeSnapshotAs(deserializeRepositoryRecord),
+	"role":              deserializeSnapshotAs(deserializeRoleRecord),
+	"sdn_controller":    deserializeSnapshotAs(deserializeSDNControllerRecord),
+	"secret":            deserializeSnapshotAs(deserializeSecretRecord),
+	"session":           deserializeSnapshotAs(deserializeSessionRecord),
+	"sm":                deserializeSnapshotAs(deserializeSMRecord),
+	"sr":                deserializeSnapshotAs(deserializeSRRecord),
+	"subject":           deserializeSnapshotAs(deserializeSubjectRecord),
+	"task":              deserializeSnapshotAs(deserializeTaskRecord),
+	"tunnel":            deserializeSnapshotAs(deserializeTunnelRecord),
+	"usb_group":         deserializeSnapshotAs(deserializeUSBGroupRecord),
+	"user":              deserializeSnapshotAs(deserializeUserRecord),
+	"vbd":               deserializeSnapshotAs(deserializeVBDRecord),
+	"vbd_metrics":       deserializeSnapshotAs(deserializeVBDMetricsRecord),
+	"vdi":               deserializeSnapshotAs(deserializeVDIRecord),
+	"vgpu":              deserializeSnapshotAs(deserializeVGPURecord),
+	"vgpu_type":         deserializeSnapshotAs(deserializeVGPUTypeRecord),
+	"vif":               deserializeSnapshotAs(deserializeVIFRecord),
+	"vif_metrics":       deserializeSnapshotAs(deserializeVIFMetricsRecord),
+	"vlan":              deserializeSnapshotAs(deserializeVLANRecord),
+	"vm":                deserializeSnapshotAs(deserializeVMRecord),
+	"vm_appliance":      deserializeSnapshotAs(deserializeVMApplianceRecord),
+	"vm_group":          deserializeSnapshotAs(deserializeVMGroupRecord),
+	"vm_guest_metrics":  deserializeSnapshotAs(deserializeVMGuestMetricsRecord),
+	"vm_metrics":        deserializeSnapshotAs(deserializeVMMetricsRecord),
+	"vmpp":              deserializeSnapshotAs(deserializeVMPPRecord),
+	"vmss":              deserializeSnapshotAs(deserializeVMSSRecord),
+	"vtpm":              deserializeSnapshotAs(deserializeVTPMRecord),
+	"vusb":              deserializeSnapshotAs(deserializeVUSBRecord),
+}
+
+// EventSnapshot returns the snapshot of the event decoded as a record of type
+// T, such as VMRecord for events of class "VM". It returns false if the event
+// carries no snapshot, a snapshot of another class or one that cannot be
+// decoded, which is left as is in record.Snapshot.
+func EventSnapshot[T any](record EventRecord) (T, bool) {
+	if snapshot, ok := record.Snapshot.(T); ok {
+		return snapshot, true
+	}
+	var zero T
+	deserialize, ok := snapshotDeserializers[strings.ToLower(record.Class)]
+	if !ok || record.Snapshot == nil {
+		return zero, false
+	}
+	decoded, err := deserialize("event.snapshot", record.Snapshot)
+	if err != nil {
+		return zero, false
+	}
+	snapshot, ok := decoded.(T)
+	return snapshot, ok
+}
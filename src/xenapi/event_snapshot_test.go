@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi_test
+
+import (
+	"testing"
+
+	"go/xenapi"
+)
+
+func TestEventSnapshotDeserialization(t *testing.T) {
+	event, err := xenapi.DeserializeEventRecord("", map[string]interface{}{
+		"id":        "42",
+		"timestamp": "20240101T12:30:45Z",
+		"class":     "VM",
+		"operation": "mod",
+		"ref":       "OpaqueRef:6c5a1a0e-0000-0000-0000-000000000001",
+		"obj_uuid":  "6c5a1a0e-0000-0000-0000-000000000002",
+		"snapshot": map[string]interface{}{
+			"uuid":        "6c5a1a0e-0000-0000-0000-000000000002",
+			"name_label":  "web01",
+			"power_state": "Running",
+			"VCPUs_max":   "4",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, ok := xenapi.EventSnapshot[xenapi.VMRecord](event)
+	if !ok {
+		t.Fatalf("expected a VMRecord snapshot, got %T", event.Snapshot)
+	}
+	if vm.NameLabel != "web01" || vm.PowerState != xenapi.VMPowerStateRunning || vm.VCPUsMax != 4 {
+		t.Fatalf("unexpected snapshot %+v", vm)
+	}
+	if _, ok := xenapi.EventSnapshot[xenapi.HostRecord](event); ok {
+		t.Fatal("expected no HostRecord snapshot for a VM event")
+	}
+
+	// A snapshot that cannot be decoded does not fail the event
+	event, err = xenapi.DeserializeEventRecord("", map[string]interface{}{
+		"class":     "VM",
+		"operation": "mod",
+		"snapshot":  map[string]interface{}{"VCPUs_max": "many"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := xenapi.EventSnapshot[xenapi.VMRecord](event); ok {
+		t.Fatal("expected no VMRecord for an invalid snapshot")
+	}
+
+	unknown := map[string]interface{}{"uuid": "6c5a1a0e-0000-0000-0000-000000000003"}
+	event, err = xenapi.DeserializeEventRecord("", map[string]interface{}{
+		"class":     "not_a_class",
+		"operation": "add",
+		"snapshot":  unknown,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if raw, ok := xenapi.EventSnapshot[map[string]interface{}](event); !ok || raw["uuid"] != unknown["uuid"] {
+		t.Fatalf("expected the raw snapshot of an unknown class, got %#v", event.Snapshot)
+	}
+}
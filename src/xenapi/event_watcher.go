@@ -0,0 +1,181 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// WatchedEvents is a batch of events delivered by an EventWatcher.
+type WatchedEvents struct {
+	// Resync is set when Events hold an add event for every object of the
+	// watched classes instead of the changes since the previous batch. The
+	// consumer should then replace whatever state it keeps with them.
+	Resync bool
+	Events []EventRecord
+	// Token to pass to event.from to get the events that follow this batch
+	Token string
+}
+
+type EventWatcherOpts struct {
+	// Classes to watch, as accepted by event.from, e.g. "VM" or "*"
+	Classes []string
+	// TokenFile, if set, keeps the token of the last delivered batch so that
+	// a restarted watcher resumes from it instead of resynchronising
+	TokenFile string
+	// Timeout of each event.from call in seconds, 30 if zero
+	Timeout float64
+	// RetryInterval is the wait before retrying after an error, 5s if zero
+	RetryInterval time.Duration
+	// OnError, if set, is called with every error the watcher recovers from
+	OnError func(err error)
+}
+
+// EventWatcher follows event.from on a session and delivers the events on a
+// channel. It resynchronises when events were lost, and retries with the same
+// token after other errors. The session should be kept logged in with
+// KeepLoggedIn, which logs it in again when it becomes invalid, e.g. after
+// xapi restarted; the watcher stops on an invalid session that is not.
+type EventWatcher struct {
+	session *Session
+	opts    EventWatcherOpts
+}
+
+func NewEventWatcher(session *Session, opts *EventWatcherOpts) *EventWatcher {
+	watcher := &EventWatcher{session: session, opts: *opts}
+	if watcher.opts.Timeout == 0 {
+		watcher.opts.Timeout = 30
+	}
+	if watcher.opts.RetryInterval == 0 {
+		watcher.opts.RetryInterval = 5 * time.Second
+	}
+	return watcher
+}
+
+// Watch starts watching in the background. The channel is closed once ctx is
+// cancelled, or once the session is invalid if it is not kept logged in.
+func (watcher *EventWatcher) Watch(ctx context.Context) <-chan WatchedEvents {
+	ch := make(chan WatchedEvents)
+	go watcher.run(ctx, ch)
+	return ch
+}
+
+func (watcher *EventWatcher) run(ctx context.Context, ch chan<- WatchedEvents) {
+	defer close(ch)
+	token, err := watcher.loadToken()
+	if err != nil {
+		watcher.report(err)
+	}
+	session := watcher.session.WithContext(ctx)
+	for ctx.Err() == nil {
+		batch, err := Event.From(session, watcher.opts.Classes, token, watcher.opts.Timeout)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			watcher.report(fmt.Errorf("event.from: %w", err))
+			switch {
+			case errors.Is(err, ErrSessionInvalid) && session.client.auth == nil:
+				return
+			case errors.Is(err, ErrEventsLost), errors.Is(err, ErrEventFromTokenParseFailure):
+				token = ""
+			default:
+				watcher.wait(ctx)
+			}
+			continue
+		}
+		select {
+		case ch <- WatchedEvents{Resync: token == "", Events: batch.Events, Token: batch.Token}:
+		case <-ctx.Done():
+			return
+		}
+		token = batch.Token
+		if err := watcher.saveToken(token); err != nil {
+			watcher.report(err)
+		}
+	}
+}
+
+func (watcher *EventWatcher) wait(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(watcher.opts.RetryInterval):
+	}
+}
+
+func (watcher *EventWatcher) report(err error) {
+	if watcher.opts.OnError != nil {
+		watcher.opts.OnError(err)
+	}
+}
+
+func (watcher *EventWatcher) loadToken() (string, error) {
+	if watcher.opts.TokenFile == "" {
+		return "", nil
+	}
+	token, err := os.ReadFile(watcher.opts.TokenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read event token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// saveToken writes the token next to the token file and renames it over, so
+// that a crash never leaves a truncated token behind.
+func (watcher *EventWatcher) saveToken(token string) error {
+	if watcher.opts.TokenFile == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(watcher.opts.TokenFile), filepath.Base(watcher.opts.TokenFile)+".*")
+	if err != nil {
+		return fmt.Errorf("could not save event token: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(token); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not save event token: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not save event token: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), watcher.opts.TokenFile); err != nil {
+		return fmt.Errorf("could not save event token: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go/xenapi"
+)
+
+// fakeEventServer answers event.from with the given responses, keyed by the
+// token of the call; tokens with no response get an empty batch.
+func fakeEventServer(t *testing.T, responses map[string][]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request xenapi.Request
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+			return
+		}
+		token := request.Params.([]interface{})[2].(string)
+		response := map[string]interface{}{"jsonrpc": "2.0", "id": request.ID,
+			"result": map[string]interface{}{"token": token, "validRefCounts": map[string]interface{}{}, "events": []interface{}{}}}
+		if queue := responses[token]; len(queue) > 0 {
+			response = queue[0]
+			responses[token] = queue[1:]
+		}
+		if err := json.NewEncoder(w).Encode(response); err != nil {
+			t.Error(err)
+		}
+	}))
+}
+
+func TestEventWatcherRecovery(t *testing.T) {
+	vm := map[string]interface{}{"uuid": "6c5a1a0e-0000-0000-0000-000000000002", "name_label": "web01"}
+	batch := func(token string, operation string) map[string]interface{} {
+		return map[string]interface{}{"jsonrpc": "2.0", "result": map[string]interface{}{
+			"token":          token,
+			"validRefCounts": map[string]interface{}{"VM": 1},
+			"events": []interface{}{map[string]interface{}{
+				"class": "VM", "operation": operation, "ref": "OpaqueRef:1", "snapshot": vm,
+			}},
+		}}
+	}
+	apiError := func(code xenapi.ErrorCode) map[string]interface{} {
+		return map[string]interface{}{"jsonrpc": "2.0", "error": map[string]interface{}{"code": 1, "message": code, "data": []interface{}{}}}
+	}
+	server := fakeEventServer(t, map[string][]map[string]interface{}{
+		"stale": {apiError(xenapi.ErrorEventsLost)},
+		"":      {batch("1", "add")},
+		"1":     {apiError(xenapi.ErrorInternalError), batch("2", "mod")},
+	})
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("stale\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	watcher := xenapi.NewEventWatcher(xenapi.NewSession(&xenapi.ClientOpts{URL: server.URL}), &xenapi.EventWatcherOpts{
+		Classes:       []string{"VM"},
+		TokenFile:     tokenFile,
+		RetryInterval: time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	events := watcher.Watch(ctx)
+
+	resync := <-events
+	if !resync.Resync || resync.Token != "1" || len(resync.Events) != 1 {
+		t.Fatalf("expected a resync after lost events, got %+v", resync)
+	}
+	if record, ok := xenapi.EventSnapshot[xenapi.VMRecord](resync.Events[0]); !ok || record.NameLabel != "web01" {
+		t.Fatalf("expected a VMRecord snapshot, got %#v", resync.Events[0].Snapshot)
+	}
+	update := <-events
+	if update.Resync || update.Token != "2" || update.Events[0].Operation != xenapi.EventOperationMod {
+		t.Fatalf("expected the changes after retrying, got %+v", update)
+	}
+
+	cancel()
+	for range events {
+	}
+	token, err := os.ReadFile(tokenFile)
+	if err != nil || string(token) != "2" {
+		t.Fatalf("expected token 2 to be saved, got %q (%v)", token, err)
+	}
+}
+
+func TestEventWatcherSessionInvalid(t *testing.T) {
+	fake := &fakeLoginServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	login := func(session *xenapi.Session) error {
+		_, err := session.LoginWithPassword("root", "secret", "1.0", "test")
+		return err
+	}
+	opts := &xenapi.EventWatcherOpts{Classes: []string{"VM"}, RetryInterval: time.Millisecond}
+
+	// A session kept logged in logs in again and the watcher carries on
+	kept := xenapi.NewSession(&xenapi.ClientOpts{URL: server.URL})
+	if err := kept.KeepLoggedIn(login); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := xenapi.NewEventWatcher(kept, opts).Watch(ctx)
+	if batch := <-events; batch.Token != "1" {
+		t.Fatalf("expected the events of the first session, got %+v", batch)
+	}
+	fake.expire()
+	for batch := range events {
+		if batch.Token == "2" {
+			break
+		}
+	}
+	if kept.Relogins() != 1 {
+		t.Fatalf("expected the watched session to log in again once, got %d", kept.Relogins())
+	}
+	cancel()
+	for range events {
+	}
+
+	// Any other session stops the watcher
+	session := xenapi.NewSession(&xenapi.ClientOpts{URL: server.URL})
+	if err := login(session); err != nil {
+		t.Fatal(err)
+	}
+	events = xenapi.NewEventWatcher(session, opts).Watch(context.Background())
+	<-events
+	fake.expire()
+	for range events {
+	}
+}
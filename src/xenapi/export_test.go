@@ -35,3 +35,17 @@ package xenapi
 // DeserializeTime is a private function that deserializes a time value.
 // It is exported for testing to allow verification of its functionality.
 var DeserializeTime = deserializeTime
+
+// ParseRRDUpdates is a private function that decodes a /rrd_updates response.
+// It is exported for testing to allow verification of its functionality.
+var ParseRRDUpdates = parseRRDUpdates
+
+// DeserializeEventRecord is a private function that deserializes an event.
+// It is exported for testing to allow verification of its functionality.
+var DeserializeEventRecord = deserializeEventRecord
+
+// SetPoolMembers sets the addresses of the pool members of a session without
+// logging it in.
+func SetPoolMembers(session *Session, members []string) {
+	session.client.target.setMembers(members)
+}
@@ -38,7 +38,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -60,6 +60,49 @@ type ResponseError struct {
 	Data    interface{} `json:"data,omitempty"`
 }
 
+// APIError is the error returned by a call that XAPI failed. It matches the
+// ErrorCode sentinels with errors.Is:
+//
+//	if errors.Is(err, xenapi.ErrHostIsSlave) { ... }
+type APIError struct {
+	// Code is the name of the error, e.g. ErrorHostIsSlave
+	Code ErrorCode
+	// Params are the parameters of the error, e.g. the address of the
+	// coordinator for ErrorHostIsSlave
+	Params []string
+	// Method is the XenAPI method that failed, e.g. "VM.start"
+	Method string
+	// RPCCode is the code of the JSON-RPC error
+	RPCCode int
+}
+
+func newAPIError(method string, response *ResponseError) *APIError {
+	err := &APIError{Code: ErrorCode(response.Message), Method: method, RPCCode: response.Code}
+	switch data := response.Data.(type) {
+	case nil:
+	case []interface{}:
+		for _, param := range data {
+			err.Params = append(err.Params, fmt.Sprint(param))
+		}
+	default:
+		err.Params = []string{fmt.Sprint(data)}
+	}
+	return err
+}
+
+func (err *APIError) Error() string {
+	errString := fmt.Sprintf("API error: code %d, message %s", err.RPCCode, err.Code)
+	if len(err.Params) > 0 {
+		errString += fmt.Sprintf(", data %v", err.Params)
+	}
+	return errString + ", method " + err.Method
+}
+
+func (err *APIError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == err.Code
+}
+
 type Response struct {
 	JSONRPC string         `json:"jsonrpc"`
 	Result  interface{}    `json:"result,omitempty"`
@@ -68,9 +111,21 @@ type Response struct {
 }
 
 type rpcClient struct {
-	endpoint   string
+	target     *rpcTarget
+	auth       *sessionAuth
 	httpClient *http.Client
 	headers    map[string]string
+	// ctx is the context calls are made with, context.Background() if nil
+	ctx context.Context
+	// err is returned by every call when the client options are invalid
+	err error
+}
+
+func (client *rpcClient) context() context.Context {
+	if client.ctx == nil {
+		return context.Background()
+	}
+	return client.ctx
 }
 
 func (client *rpcClient) newRequest(ctx context.Context, req interface{}) (*http.Request, error) {
@@ -79,7 +134,7 @@ func (client *rpcClient) newRequest(ctx context.Context, req interface{}) (*http
 		return nil, fmt.Errorf("error marshaling request: %w", err)
 	}
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, client.endpoint, bytes.NewReader(dataByte))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, client.target.endpoint(), bytes.NewReader(dataByte))
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
@@ -143,18 +198,17 @@ func (client *rpcClient) call(ctx context.Context, methodName string, params ...
 	return rpcResponse, nil
 }
 
-func (client *rpcClient) sendCall(methodName string, params ...interface{}) (result interface{}, err error) {
-	response, err := client.call(context.Background(), methodName, params...)
+func (client *rpcClient) sendCallOnce(methodName string, params ...interface{}) (result interface{}, err error) {
+	if client.err != nil {
+		return nil, client.err
+	}
+	response, err := client.call(client.context(), methodName, params...)
 	if err != nil {
 		return
 	}
 
 	if response.Error != nil {
-		errString := fmt.Sprintf("API error: code %d, message %s", response.Error.Code, response.Error.Message)
-		if response.Error.Data != nil {
-			errString += fmt.Sprintf(", data %v", response.Error.Data)
-		}
-		err = errors.New(errString)
+		err = newAPIError(methodName, response.Error)
 		return
 	}
 
@@ -173,19 +227,34 @@ type ClientOpts struct {
 	SecureOpts *SecureOpts
 	Timeout    int
 	Headers    map[string]string
+	// DialTimeout bounds connecting to a host, 5s if zero, so that calls fail
+	// over to another pool member before they time out when it is down
+	DialTimeout time.Duration
 }
 
 func newJSONRPCClient(opts *ClientOpts) *rpcClient {
 	client := &rpcClient{
-		endpoint:   fmt.Sprintf("%s%s", opts.URL, "/jsonrpc"),
+		target:     &rpcTarget{baseURL: opts.URL},
 		httpClient: &http.Client{},
 		headers:    make(map[string]string),
 	}
+	if err := client.configure(opts); err != nil {
+		client.err = fmt.Errorf("invalid client options: %w", err)
+	}
+	return client
+}
 
+func (client *rpcClient) configure(opts *ClientOpts) error {
 	u, err := url.Parse(opts.URL)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	dialTimeout := opts.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 5 * time.Second
 	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
 	if strings.Compare(u.Scheme, "https") == 0 {
 		skipVerify := true
 		caCertPool := x509.NewCertPool()
@@ -195,23 +264,23 @@ func newJSONRPCClient(opts *ClientOpts) *rpcClient {
 				skipVerify = false
 				caCert, err := os.ReadFile(opts.SecureOpts.ServerCert)
 				if err != nil {
-					log.Fatal(err)
+					return err
 				}
 				ok := caCertPool.AppendCertsFromPEM(caCert)
 				if !ok {
-					log.Fatal("failed to parse CA certificate")
+					return errors.New("failed to parse CA certificate")
 				}
 			}
 			if opts.SecureOpts.ClientCert != "" || opts.SecureOpts.ClientKey != "" {
 				if opts.SecureOpts.ClientCert == "" {
-					log.Fatal("missing client certificate")
+					return errors.New("missing client certificate")
 				}
 				if opts.SecureOpts.ClientKey == "" {
-					log.Fatal("missing client private key")
+					return errors.New("missing client private key")
 				}
 				cert, err := tls.LoadX509KeyPair(opts.SecureOpts.ClientCert, opts.SecureOpts.ClientKey)
 				if err != nil {
-					log.Fatal(err)
+					return err
 				}
 				certs = []tls.Certificate{cert}
 			}
@@ -227,11 +296,9 @@ func newJSONRPCClient(opts *ClientOpts) *rpcClient {
 			MinVersion:               tls.VersionTLS12,
 			PreferServerCipherSuites: true,
 		}
-		transport := &http.Transport{
-			TLSClientConfig: tlsConfig,
-		}
-		client.httpClient.Transport = transport
+		transport.TLSClientConfig = tlsConfig
 	}
+	client.httpClient.Transport = transport
 
 	if opts.Timeout != 0 {
 		client.httpClient.Timeout = time.Duration(opts.Timeout) * time.Second
@@ -243,5 +310,5 @@ func newJSONRPCClient(opts *ClientOpts) *rpcClient {
 		}
 	}
 
-	return client
+	return nil
 }
@@ -0,0 +1,373 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RRDUpdatesOpts selects the data returned by the /rrd_updates HTTP handler.
+type RRDUpdatesOpts struct {
+	// Address of the host to query. Each host only serves the RRDs of itself
+	// and of its resident VMs. Defaults to the host of the session URL.
+	Address string
+	// Only rows newer than Start are returned
+	Start time.Time
+	// Consolidation function: AVERAGE, MIN, MAX or LAST. Defaults to AVERAGE.
+	CF string
+	// Step in seconds of the archive to read rows from. Defaults to 5.
+	Interval int
+	// Include the data sources of the host itself, not only its VMs
+	Host bool
+	// Restrict the output to a single VM
+	VMUUID string
+	// Request JSON instead of XML output
+	JSON bool
+}
+
+type RRDLegendEntry struct {
+	// Consolidation function of the column
+	CF string
+	// Type of the object the column belongs to: host, vm or sr
+	ObjectType string
+	// UUID of the object the column belongs to
+	UUID string
+	// Name of the data source, for instance cpu0 or vif_0_rx
+	Name string
+}
+
+type RRDRow struct {
+	Timestamp time.Time
+	// One value per legend entry, in legend order
+	Values []float64
+}
+
+type RRDUpdatesResult struct {
+	Start  time.Time
+	End    time.Time
+	Step   int
+	Legend []RRDLegendEntry
+	// Rows as sent by XAPI, newest first
+	Rows []RRDRow
+}
+
+// Column returns the index of the legend entry matching objectType, uuid and
+// name, or -1 if there is none.
+func (result RRDUpdatesResult) Column(objectType string, uuid string, name string) int {
+	for index, entry := range result.Legend {
+		if entry.ObjectType == objectType && entry.UUID == uuid && entry.Name == name {
+			return index
+		}
+	}
+	return -1
+}
+
+// Performance data of hosts and VMs served over HTTP by XAPI
+type rrdUpdates struct{}
+
+var RRDUpdates rrdUpdates
+
+// Get: Download the rows of the round robin databases added since opts.Start
+func (rrdUpdates) Get(session *Session, opts *RRDUpdatesOpts) (retval RRDUpdatesResult, err error) {
+	return RRDUpdates.GetWithContext(session.client.context(), session, opts)
+}
+
+// GetWithContext: Same as Get, aborting the download when ctx is done
+func (rrdUpdates) GetWithContext(ctx context.Context, session *Session, opts *RRDUpdatesOpts) (retval RRDUpdatesResult, err error) {
+	ref := session.Ref()
+	retval, status, err := getRRDUpdates(ctx, session, opts, ref)
+	// The session expired: log in again and retry once, as calls do
+	if (status == http.StatusUnauthorized || status == http.StatusForbidden) && session.client.auth != nil {
+		ref, renewErr := session.client.auth.renew(ctx, ref)
+		if renewErr != nil {
+			return retval, fmt.Errorf("%w, logging in again failed: %w", err, renewErr)
+		}
+		retval, _, err = getRRDUpdates(ctx, session, opts, ref)
+	}
+	return
+}
+
+func getRRDUpdates(ctx context.Context, session *Session, opts *RRDUpdatesOpts, ref SessionRef) (retval RRDUpdatesResult, status int, err error) {
+	if session.client.err != nil {
+		return retval, 0, session.client.err
+	}
+	requestURL, err := rrdUpdatesURL(session, opts, ref)
+	if err != nil {
+		return
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL.String(), nil)
+	if err != nil {
+		return retval, 0, fmt.Errorf("error creating request: %w", err)
+	}
+	for k, v := range session.client.headers {
+		request.Header.Set(k, v)
+	}
+	// The query holds the session reference, keep it out of the errors
+	redacted := redactedURL(requestURL)
+
+	response, err := session.client.httpClient.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return retval, 0, fmt.Errorf("get %v. Error making http request: %w", redacted, err)
+	}
+	defer response.Body.Close()
+	status = response.StatusCode
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return retval, status, fmt.Errorf("get %v status code: %v. Could not read response body: %w", redacted, status, err)
+	}
+	if status != http.StatusOK {
+		return retval, status, fmt.Errorf("get %v status code: %v. %s", redacted, status, strings.TrimSpace(string(body)))
+	}
+	retval, err = parseRRDUpdates(body, opts.JSON)
+	if err != nil {
+		return retval, status, fmt.Errorf("get %v: %w", redacted, err)
+	}
+	return
+}
+
+// redactedURL returns u without its query and password.
+func redactedURL(u *url.URL) string {
+	redacted := *u
+	redacted.RawQuery = ""
+	return redacted.Redacted()
+}
+
+// urlHost returns the host part of a URL for address, using port unless the
+// address carries its own. IPv6 addresses are bracketed.
+func urlHost(address string, port string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil || strings.HasPrefix(address, "[") {
+		return address
+	}
+	if port != "" {
+		return net.JoinHostPort(address, port)
+	}
+	if strings.Contains(address, ":") {
+		return "[" + address + "]"
+	}
+	return address
+}
+
+func rrdUpdatesURL(session *Session, opts *RRDUpdatesOpts, ref SessionRef) (*url.URL, error) {
+	u, err := url.Parse(session.client.target.url())
+	if err != nil {
+		return nil, err
+	}
+	if opts.Address != "" {
+		u.Host = urlHost(opts.Address, u.Port())
+	}
+	u.Path = "/rrd_updates"
+
+	cf := opts.CF
+	if cf == "" {
+		cf = "AVERAGE"
+	}
+	interval := opts.Interval
+	if interval == 0 {
+		interval = 5
+	}
+	query := url.Values{}
+	query.Set("session_id", string(ref))
+	query.Set("start", strconv.FormatInt(opts.Start.Unix(), 10))
+	query.Set("cf", cf)
+	query.Set("interval", strconv.Itoa(interval))
+	if opts.Host {
+		query.Set("host", "true")
+	}
+	if opts.VMUUID != "" {
+		query.Set("vm_uuid", opts.VMUUID)
+	}
+	if opts.JSON {
+		query.Set("json", "true")
+	}
+	u.RawQuery = query.Encode()
+	return u, nil
+}
+
+type rrdXport struct {
+	Meta struct {
+		Start  int64    `xml:"start"`
+		Step   int      `xml:"step"`
+		End    int64    `xml:"end"`
+		Legend []string `xml:"legend>entry"`
+	} `xml:"meta"`
+	Rows []struct {
+		Time   int64    `xml:"t"`
+		Values []string `xml:"v"`
+	} `xml:"data>row"`
+}
+
+type rrdJSONXport struct {
+	Meta struct {
+		Start  interface{} `json:"start"`
+		Step   interface{} `json:"step"`
+		End    interface{} `json:"end"`
+		Legend []string    `json:"legend"`
+	} `json:"meta"`
+	Rows []struct {
+		Time   interface{}   `json:"t"`
+		Values []interface{} `json:"values"`
+	} `json:"data"`
+}
+
+var (
+	rrdBareKeyRegexp     = regexp.MustCompile(`([{,]\s*)([A-Za-z_][A-Za-z0-9_]*)\s*:`)
+	rrdSpecialFloatRegex = regexp.MustCompile(`([:,\[]\s*)([+-]?Infinity|[+-]?inf|NaN|nan)\b`)
+)
+
+// Older XAPI versions write the legend and metadata keys without quotes and
+// emit NaN and Infinity literals, neither of which encoding/json accepts.
+func convertRRDJSONData(body []byte) []byte {
+	body = rrdBareKeyRegexp.ReplaceAll(body, []byte(`$1"$2":`))
+	return rrdSpecialFloatRegex.ReplaceAllFunc(body, func(match []byte) []byte {
+		groups := rrdSpecialFloatRegex.FindSubmatch(match)
+		value := "NaN"
+		switch strings.ToLower(string(groups[2])) {
+		case "infinity", "+infinity", "inf", "+inf":
+			value = "+Inf"
+		case "-infinity", "-inf":
+			value = "-Inf"
+		}
+		return append(groups[1], []byte(`"`+value+`"`)...)
+	})
+}
+
+func parseRRDUpdates(body []byte, isJSON bool) (retval RRDUpdatesResult, err error) {
+	if isJSON {
+		return parseRRDUpdatesJSON(body)
+	}
+	return parseRRDUpdatesXML(body)
+}
+
+func parseRRDUpdatesXML(body []byte) (retval RRDUpdatesResult, err error) {
+	var xport rrdXport
+	err = xml.Unmarshal(body, &xport)
+	if err != nil {
+		return retval, fmt.Errorf("could not decode rrd_updates XML: %w", err)
+	}
+	retval.Start = time.Unix(xport.Meta.Start, 0).UTC()
+	retval.End = time.Unix(xport.Meta.End, 0).UTC()
+	retval.Step = xport.Meta.Step
+	retval.Legend, err = parseRRDLegend(xport.Meta.Legend)
+	if err != nil {
+		return
+	}
+	retval.Rows = make([]RRDRow, len(xport.Rows))
+	for index, row := range xport.Rows {
+		if len(row.Values) != len(retval.Legend) {
+			return retval, fmt.Errorf("rrd_updates row %d has %d values, legend has %d entries", index, len(row.Values), len(retval.Legend))
+		}
+		retval.Rows[index].Timestamp = time.Unix(row.Time, 0).UTC()
+		retval.Rows[index].Values = make([]float64, len(row.Values))
+		for column, value := range row.Values {
+			retval.Rows[index].Values[column], err = deserializeFloat(fmt.Sprintf("rrd_updates.data[%d][%d]", index, column), strings.TrimSpace(value))
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func parseRRDUpdatesJSON(body []byte) (retval RRDUpdatesResult, err error) {
+	var xport rrdJSONXport
+	err = json.Unmarshal(convertRRDJSONData(body), &xport)
+	if err != nil {
+		return retval, fmt.Errorf("could not decode rrd_updates JSON: %w", err)
+	}
+	retval.Start, err = deserializeTime("rrd_updates.meta.start", xport.Meta.Start)
+	if err != nil {
+		return
+	}
+	retval.End, err = deserializeTime("rrd_updates.meta.end", xport.Meta.End)
+	if err != nil {
+		return
+	}
+	retval.Step, err = deserializeInt("rrd_updates.meta.step", xport.Meta.Step)
+	if err != nil {
+		return
+	}
+	retval.Legend, err = parseRRDLegend(xport.Meta.Legend)
+	if err != nil {
+		return
+	}
+	retval.Rows = make([]RRDRow, len(xport.Rows))
+	for index, row := range xport.Rows {
+		if len(row.Values) != len(retval.Legend) {
+			return retval, fmt.Errorf("rrd_updates row %d has %d values, legend has %d entries", index, len(row.Values), len(retval.Legend))
+		}
+		retval.Rows[index].Timestamp, err = deserializeTime(fmt.Sprintf("rrd_updates.data[%d].t", index), row.Time)
+		if err != nil {
+			return
+		}
+		retval.Rows[index].Values = make([]float64, len(row.Values))
+		for column, value := range row.Values {
+			retval.Rows[index].Values[column], err = deserializeFloat(fmt.Sprintf("rrd_updates.data[%d][%d]", index, column), value)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Legend entries have the form CF:object_type:uuid:data_source
+func parseRRDLegend(legend []string) ([]RRDLegendEntry, error) {
+	entries := make([]RRDLegendEntry, len(legend))
+	for index, entry := range legend {
+		fields := strings.SplitN(strings.TrimSpace(entry), ":", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid rrd_updates legend entry %q", entry)
+		}
+		entries[index] = RRDLegendEntry{
+			CF:         fields[0],
+			ObjectType: fields[1],
+			UUID:       fields[2],
+			Name:       fields[3],
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi_test
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go/xenapi"
+)
+
+const rrdUpdatesXML = `<?xml version="1.0" encoding="UTF-8"?>
+<xport><meta><start>1700000000</start><step>5</step><end>1700000010</end><rows>2</rows><columns>2</columns>
+<legend><entry>AVERAGE:host:6c5a1a0e-0000-0000-0000-000000000001:cpu0</entry><entry>AVERAGE:vm:6c5a1a0e-0000-0000-0000-000000000002:vif_0_rx</entry></legend></meta>
+<data><row><t>1700000010</t><v>0.25</v><v>NaN</v></row><row><t>1700000005</t><v>0.5</v><v>1024.0</v></row></data></xport>`
+
+const rrdUpdatesJSON = `{meta: {start: 1700000000, step: 5, end: 1700000010, rows: 2, columns: 2,
+legend: ["AVERAGE:host:6c5a1a0e-0000-0000-0000-000000000001:cpu0", "AVERAGE:vm:6c5a1a0e-0000-0000-0000-000000000002:vif_0_rx"]},
+data: [{t: 1700000010, values: [0.25, NaN]}, {t: 1700000005, values: [0.5, 1024.0]}]}`
+
+func TestRRDUpdatesParsing(t *testing.T) {
+	inputs := map[string]struct {
+		body   string
+		isJSON bool
+	}{
+		"xml":  {rrdUpdatesXML, false},
+		"json": {rrdUpdatesJSON, true},
+	}
+	for name, input := range inputs {
+		t.Run(name, func(t *testing.T) {
+			result, err := xenapi.ParseRRDUpdates([]byte(input.body), input.isJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !result.End.Equal(time.Unix(1700000010, 0)) || result.Step != 5 {
+				t.Fatalf("unexpected metadata: end %v, step %d", result.End, result.Step)
+			}
+			if len(result.Legend) != 2 || len(result.Rows) != 2 {
+				t.Fatalf("expected 2 legend entries and 2 rows, got %d and %d", len(result.Legend), len(result.Rows))
+			}
+			column := result.Column("vm", "6c5a1a0e-0000-0000-0000-000000000002", "vif_0_rx")
+			if column != 1 || result.Legend[column].CF != "AVERAGE" {
+				t.Fatalf("unexpected legend %+v", result.Legend)
+			}
+			if !math.IsNaN(result.Rows[0].Values[1]) || result.Rows[1].Values[1] != 1024 {
+				t.Fatalf("unexpected values %+v", result.Rows)
+			}
+			if !result.Rows[1].Timestamp.Equal(time.Unix(1700000005, 0)) {
+				t.Fatalf("unexpected timestamp %v", result.Rows[1].Timestamp)
+			}
+		})
+	}
+}
+
+func TestRRDUpdatesAddress(t *testing.T) {
+	results := map[string]string{
+		"session.login_with_password": `"OpaqueRef:rrd-session"`,
+		"pool.get_all":                `["OpaqueRef:pool"]`,
+		"pool.get_record":             `{"master":"OpaqueRef:host"}`,
+		"host.get_record":             `{"API_version_major":2,"API_version_minor":21,"software_version":{"xapi":"24.0"}}`,
+		"host.get_all_records":        `{}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request xenapi.Request
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			return
+		}
+		_, _ = w.Write([]byte(`{"jsonrpc":"2.0","id":0,"result":` + results[request.Method] + `}`))
+	}))
+	defer server.Close()
+	session := xenapi.NewSession(&xenapi.ClientOpts{URL: server.URL})
+	if _, err := session.LoginWithPassword("root", "secret", "1.0", "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on the IPv6 loopback at the port of the server
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	_, err := xenapi.RRDUpdates.Get(session, &xenapi.RRDUpdatesOpts{Address: "::1"})
+	if err == nil {
+		t.Fatal("expected the download to fail")
+	}
+	if !strings.Contains(err.Error(), "[::1]:"+port+"/rrd_updates") || strings.Contains(err.Error(), "OpaqueRef:rrd-session") {
+		t.Fatalf("expected the error to name [::1]:%s without the session, got %v", port, err)
+	}
+}
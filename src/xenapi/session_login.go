@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sessionAuth keeps a session logged in. It is shared by the copies of the
+// session: the ref they were made with, that of the first login, stays in
+// their calls and is replaced with the current one when sent.
+type sessionAuth struct {
+	login func(session *Session) error
+	// session is copied to log in again, so that the ref of the session and
+	// its copies never changes under a running call
+	session Session
+	renewMu sync.Mutex
+
+	mu sync.RWMutex
+	// initial is the ref of the first login, current the ref to use
+	initial   SessionRef
+	ref       SessionRef
+	loginTime time.Time
+	relogins  int
+	closed    bool
+}
+
+// KeepLoggedIn logs the session in with login and logs it in again whenever
+// XAPI reports it invalid, e.g. after it expired or xapi was restarted. The
+// call that failed is then sent again once with the new session. login
+// typically calls LoginWithPassword on the session it is given, which may be
+// a copy. Logging out stops the re-logins.
+func (class *Session) KeepLoggedIn(login func(session *Session) error) error {
+	return class.KeepLoggedInWithContext(class.Context(), login)
+}
+
+// KeepLoggedInWithContext: Same as KeepLoggedIn, aborting the first login when
+// ctx is done. Later logins are bound to the context of the call that failed.
+func (class *Session) KeepLoggedInWithContext(ctx context.Context, login func(session *Session) error) error {
+	bound := class.WithContext(ctx)
+	if err := login(bound); err != nil {
+		return err
+	}
+	client := class.client
+	*class = *bound
+	class.client = client
+	auth := &sessionAuth{
+		login:     login,
+		session:   *class,
+		initial:   class.ref,
+		ref:       class.ref,
+		loginTime: time.Now(),
+	}
+	class.client.auth = auth
+	return nil
+}
+
+// Ref returns the reference of the session, the current one if the session
+// is kept logged in.
+func (class *Session) Ref() SessionRef {
+	if auth := class.client.auth; auth != nil {
+		auth.mu.RLock()
+		defer auth.mu.RUnlock()
+		return auth.ref
+	}
+	return class.ref
+}
+
+// LoginTime returns when a session kept logged in last logged in.
+func (class *Session) LoginTime() time.Time {
+	if auth := class.client.auth; auth != nil {
+		auth.mu.RLock()
+		defer auth.mu.RUnlock()
+		return auth.loginTime
+	}
+	return time.Time{}
+}
+
+// Relogins returns how many times a session kept logged in logged in again.
+func (class *Session) Relogins() int {
+	if auth := class.client.auth; auth != nil {
+		auth.mu.RLock()
+		defer auth.mu.RUnlock()
+		return auth.relogins
+	}
+	return 0
+}
+
+// current replaces the reference of the first login in the first parameter,
+// where every call made with a session takes it, with the current one.
+func (auth *sessionAuth) current(params []interface{}) []interface{} {
+	if len(params) == 0 {
+		return params
+	}
+	ref, ok := params[0].(string)
+	if !ok {
+		return params
+	}
+	auth.mu.RLock()
+	defer auth.mu.RUnlock()
+	if SessionRef(ref) != auth.initial || auth.ref == auth.initial {
+		return params
+	}
+	return append([]interface{}{string(auth.ref)}, params[1:]...)
+}
+
+// renew logs in again unless the stale reference was already replaced, and
+// returns the reference to use instead. The login is aborted once ctx is done,
+// so that a host that never answers does not hold up the other calls.
+func (auth *sessionAuth) renew(ctx context.Context, stale SessionRef) (SessionRef, error) {
+	auth.renewMu.Lock()
+	defer auth.renewMu.Unlock()
+	auth.mu.RLock()
+	current, closed := auth.ref, auth.closed
+	auth.mu.RUnlock()
+	if closed {
+		return "", errors.New("session was logged out")
+	}
+	if current != stale {
+		return current, nil
+	}
+
+	session := auth.session.WithContext(ctx)
+	if err := auth.login(session); err != nil {
+		return "", err
+	}
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+	auth.ref = session.ref
+	auth.loginTime = time.Now()
+	auth.relogins++
+	return auth.ref, nil
+}
+
+func (auth *sessionAuth) close() {
+	auth.mu.Lock()
+	auth.closed = true
+	auth.mu.Unlock()
+}
+
+func (client *rpcClient) sendCall(methodName string, params ...interface{}) (result interface{}, err error) {
+	auth := client.auth
+	if auth == nil {
+		return client.sendCallToCoordinator(methodName, params...)
+	}
+	params = auth.current(params)
+	result, err = client.sendCallToCoordinator(methodName, params...)
+	method := strings.TrimPrefix(methodName, "Async.")
+	switch {
+	case method == "session.logout" && err == nil:
+		auth.close()
+	case errors.Is(err, ErrSessionInvalid) && !strings.HasPrefix(method, "session.log") && len(params) > 0:
+		stale, ok := params[0].(string)
+		if !ok {
+			return
+		}
+		ref, renewErr := auth.renew(client.context(), SessionRef(stale))
+		if renewErr != nil {
+			err = fmt.Errorf("%w, logging in again failed: %w", err, renewErr)
+			return
+		}
+		result, err = client.sendCallToCoordinator(methodName, append([]interface{}{string(ref)}, params[1:]...)...)
+	}
+	return
+}
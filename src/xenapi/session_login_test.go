@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) Cloud Software Group, Inc.
+ *
+ * Redistribution and use in source and binary forms, with or without
+ * modification, are permitted provided that the following conditions
+ * are met:
+ *
+ *   1) Redistributions of source code must retain the above copyright
+ *      notice, this list of conditions and the following disclaimer.
+ *
+ *   2) Redistributions in binary form must reproduce the above
+ *      copyright notice, this list of conditions and the following
+ *      disclaimer in the documentation and/or other materials
+ *      provided with the distribution.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS
+ * "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT
+ * LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS
+ * FOR A PARTICULAR PURPOSE ARE DISCLAIMED. IN NO EVENT SHALL THE
+ * COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT,
+ * INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES
+ * (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION)
+ * HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT,
+ * STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+ * ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED
+ * OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package xenapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go/xenapi"
+)
+
+// fakeLoginServer is a pool whose sessions all become invalid when expire is
+// called.
+type fakeLoginServer struct {
+	mu     sync.Mutex
+	logins int
+	valid  string
+	// hang makes the logins never answer, like xapi while it restarts
+	hang bool
+	// Addresses of the hosts of the pool
+	members []string
+}
+
+func (server *fakeLoginServer) expire() {
+	server.mu.Lock()
+	server.valid = ""
+	server.mu.Unlock()
+}
+
+func (server *fakeLoginServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/rrd_updates" {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		if r.URL.Query().Get("session_id") != server.valid {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(rrdUpdatesXML))
+		return
+	}
+	var request xenapi.Request
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		return
+	}
+	params := request.Params.([]interface{})
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.hang && request.Method == "session.login_with_password" {
+		server.mu.Unlock()
+		<-r.Context().Done()
+		server.mu.Lock()
+		return
+	}
+	var result interface{}
+	switch request.Method {
+	case "session.login_with_password":
+		server.logins++
+		server.valid = fmt.Sprintf("OpaqueRef:session-%d", server.logins)
+		result = server.valid
+	case "pool.get_all":
+		result = []string{"OpaqueRef:pool"}
+	case "pool.get_record":
+		result = map[string]interface{}{"master": "OpaqueRef:host"}
+	case "host.get_record":
+		result = map[string]interface{}{"API_version_major": 2, "API_version_minor": 21, "software_version": map[string]string{"xapi": "24.0"}}
+	case "host.get_all_records":
+		hosts := map[string]interface{}{}
+		for i, member := range server.members {
+			hosts[fmt.Sprintf("OpaqueRef:host-%d", i)] = map[string]interface{}{"address": member}
+		}
+		result = hosts
+	case "session.logout":
+		server.valid = ""
+		result = ""
+	default:
+		if params[0] != server.valid {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "error": map[string]interface{}{"code": 1, "message": "SESSION_INVALID", "data": []interface{}{params[0]}}})
+			return
+		}
+		if request.Method == "event.from" {
+			result = map[string]interface{}{"token": fmt.Sprint(server.logins), "validRefCounts": map[string]interface{}{}, "events": []interface{}{}}
+		} else {
+			result = []string{"OpaqueRef:host"}
+		}
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "result": result})
+}
+
+func TestSessionKeepLoggedIn(t *testing.T) {
+	fake := &fakeLoginServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	session := xenapi.NewSession(&xenapi.ClientOpts{URL: server.URL})
+	err := session.KeepLoggedIn(func(session *xenapi.Session) error {
+		_, err := session.LoginWithPassword("root", "secret", "1.0", "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Copies of the session follow the new login too
+	bound := session.WithContext(context.Background())
+
+	fake.expire()
+	for _, s := range []*xenapi.Session{session, bound, session} {
+		if _, err := xenapi.Host.GetAll(s); err != nil {
+			t.Fatalf("expected the call to succeed after logging in again, got %v", err)
+		}
+	}
+	if session.Relogins() != 1 || fake.logins != 2 || session.Ref() != "OpaqueRef:session-2" {
+		t.Fatalf("expected a single re-login, got %d (%d logins), ref %s", session.Relogins(), fake.logins, session.Ref())
+	}
+
+	// Downloads over HTTP use the current session and log in again too
+	for i := 0; i < 2; i++ {
+		if _, err := xenapi.RRDUpdates.Get(session, &xenapi.RRDUpdatesOpts{}); err != nil {
+			t.Fatalf("expected the download to succeed, got %v", err)
+		}
+		fake.expire()
+		if _, err := xenapi.Host.GetAll(bound); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fake.expire()
+	if _, err := xenapi.RRDUpdates.Get(bound, &xenapi.RRDUpdatesOpts{}); err != nil || fake.logins != 5 {
+		t.Fatalf("expected the download to log in again, got %v after %d logins", err, fake.logins)
+	}
+
+	if err := session.Logout(); err != nil {
+		t.Fatal(err)
+	}
+	fake.expire()
+	if _, err := xenapi.Host.GetAll(bound); err == nil || fake.logins != 5 {
+		t.Fatalf("expected no re-login after logging out, got %v after %d logins", err, fake.logins)
+	}
+}
+
+func TestSessionLoginHangs(t *testing.T) {
+	fake := &fakeLoginServer{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	session := xenapi.NewSession(&xenapi.ClientOpts{URL: server.URL})
+	err := session.KeepLoggedIn(func(session *xenapi.Session) error {
+		_, err := session.LoginWithPassword("root", "secret", "1.0", "test")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake.mu.Lock()
+	fake.valid, fake.hang = "", true
+	fake.mu.Unlock()
+	// Every call waiting for the login gives up with its own context
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if _, err := xenapi.Host.GetAll(session.WithContext(ctx)); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected the call to time out while logging in again, got %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	fake.mu.Lock()
+	fake.hang = false
+	fake.mu.Unlock()
+	if _, err := xenapi.Host.GetAll(session); err != nil || session.Relogins() != 1 {
+		t.Fatalf("expected the next call to log in again, got %v after %d re-logins", err, session.Relogins())
+	}
+}
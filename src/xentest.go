@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"os"
 	"fmt"
+	"log"
 
 	"xenapi"
-	"log"
-	"encoding/json"
+	"time"
 )
 
 var HOST_FLAG = flag.String("host", "127.0.0.1", "the Host of the form ip[:port] pointing at the server")
@@ -30,71 +31,136 @@ type XenInterface interface {
 type SammXen struct {
 	verifySsl bool
 	Session *xenapi.Session
-	sessionRef xenapi.SessionRef
 	SessionRec xenapi.SessionRecord
+	// Cache, when set, serves the records of the classes it mirrors
+	Cache *ObjectCache
 }
 
 func NewSammXen(host string, user string, password string, verifySsl bool) (*SammXen, error) {
+	return newSammXen(context.Background(), []string{host}, nil, user, password, verifySsl)
+}
+
+// newSammXen logs in to the first of the pool members at addresses that
+// answers, giving up once ctx is done. Members that are not the coordinator
+// redirect to it.
+func newSammXen(ctx context.Context, addresses []string, secure *xenapi.SecureOpts, user string, password string, verifySsl bool) (*SammXen, error) {
+	var errs []error
+	for _, address := range addresses {
+		x, err := loginSammXen(ctx, address, secure, user, password, verifySsl)
+		if err == nil {
+			return x, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", address, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Timeout of every XAPI request in seconds, so that a host that accepts the
+// connection but never answers, e.g. while xapi restarts, cannot hang a call
+// made without a deadline. Longer than the event.from calls of the cache.
+const xapiTimeout = 2 * eventFromTimeout
+
+func loginSammXen(ctx context.Context, host string, secure *xenapi.SecureOpts, user string, password string, verifySsl bool) (*SammXen, error) {
 	x := &SammXen{
 		verifySsl: verifySsl,
 		Session: xenapi.NewSession(&xenapi.ClientOpts{
-			URL: "https://" + host,
+			URL:        "https://" + host,
+			SecureOpts: secure,
+			Timeout:    xapiTimeout,
 			Headers: map[string]string{
 				"User-Agent": "SAMM exporter v2.0",
 			},
 		}),
 	}
-	var err error
-	x.sessionRef, err = x.Session.LoginWithPassword(user, password, "1.0", "Samm exporter v2.0")
+	err := x.Session.KeepLoggedInWithContext(ctx, func(session *xenapi.Session) error {
+		_, err := session.LoginWithPassword(user, password, "1.0", "Samm exporter v2.0")
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	x.SessionRec, err = x.Session.GetRecord(x.sessionRef)
+	// Only needed to fail over, the session works without them
+	if _, err := x.Session.PoolMembers(); err != nil {
+		log.Printf("%s: %s", host, err)
+	}
+	x.SessionRec, err = x.Session.WithContext(ctx).GetRecord(x.Session.Ref())
 	if err != nil {
 		return nil, err
 	}
 	return x, nil
 }
 
+// KeepAlive touches the session every interval until ctx is done, so that it
+// is logged in again early rather than on the first call of a scrape.
+func (self SammXen) KeepAlive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	session := self.Session.WithContext(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := session.GetUUID(session.Ref()); err != nil && ctx.Err() == nil {
+				log.Printf("session keepalive: %s", err)
+			}
+		}
+	}
+}
+
+// Logout logs the session out so that it does not linger on the pool.
+func (self SammXen) Logout() error {
+	return self.Session.Logout()
+}
+
 func (self SammXen) SessionId() (string) {
 	return self.SessionRec.UUID
 }
 
-func (self SammXen) GetUpdatesRrd(hostUuid string) ([]byte, error) {
-	//var host xenapi.HostRef
-	//host, err = xenapi.Host.GetByUUID(self.sessionRef, hostUuid)
-	return json.Marshal([]string{})
+// GetUpdatesRrd downloads the RRD rows recorded since start by the host with
+// the given UUID, including the data sources of the VMs resident on it.
+func (self SammXen) GetUpdatesRrd(hostUuid string, start time.Time) (xenapi.RRDUpdatesResult, error) {
+	host, err := xenapi.Host.GetByUUID(self.Session, hostUuid)
+	if err != nil {
+		return xenapi.RRDUpdatesResult{}, fmt.Errorf("host.get_by_uuid: %w", err)
+	}
+	address, err := xenapi.Host.GetAddress(self.Session, host)
+	if err != nil {
+		return xenapi.RRDUpdatesResult{}, fmt.Errorf("host.get_address: %w", err)
+	}
+	return self.GetHostUpdatesRrd(address, start)
+}
+
+// GetHostUpdatesRrd is GetUpdatesRrd for callers that already know the
+// address of the host.
+func (self SammXen) GetHostUpdatesRrd(address string, start time.Time) (xenapi.RRDUpdatesResult, error) {
+	return xenapi.RRDUpdates.Get(self.Session, &xenapi.RRDUpdatesOpts{
+		Address: address,
+		Start:   start,
+		Host:    true,
+		JSON:    true,
+	})
 }
 
 func (self SammXen) UpdateObjects() {
 	return
 }
 
-var session *xenapi.Session
-
-func main() {
-	flag.Parse()
-	x, err := NewSammXen(*HOST_FLAG, *USERNAME_FLAG, *PASSWORD_FLAG, false)
+// Pool returns the record of the pool the session is connected to.
+func (self SammXen) Pool() (xenapi.PoolRecord, error) {
+	pools, err := self.Pools()
 	if err != nil {
-		panic(err)
-		return
-	}
-	log.Printf("%s\n", x.SessionId())
-	allHosts, nerr := xenapi.Host.GetAllRecords(x.Session)
-	if nerr != nil {
-		panic(nerr)
-		return
+		return xenapi.PoolRecord{}, err
 	}
-	for _, host := range(allHosts) {
-		if err != nil {
-			log.Print(err)
-		} else {
-			fmt.Printf("%s\n", host.UUID)
-		}
+	for _, pool := range pools {
+		return pool, nil
 	}
+	return xenapi.PoolRecord{}, fmt.Errorf("no pool record found")
+}
 
-	if err := x.Session.Logout(); err != nil {
-		log.Print(err)
-	}
-	os.Exit(0)
+// WithContext returns a copy of self whose XAPI calls are aborted once ctx is
+// done. It shares the object cache of self.
+func (self SammXen) WithContext(ctx context.Context) *SammXen {
+	self.Session = self.Session.WithContext(ctx)
+	return &self
 }